@@ -0,0 +1,75 @@
+// Package v1alpha1 contains the NetboxSyncPolicy API used to configure the syncer declaratively.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersion is the API group and version of NetboxSyncPolicy
+var GroupVersion = schema.GroupVersion{Group: "netbox-syncer.io", Version: "v1alpha1"}
+
+// NetboxSyncPolicyResource is the cluster-scoped NetboxSyncPolicy resource
+var NetboxSyncPolicyResource = GroupVersion.WithResource("netboxsyncpolicies")
+
+// DeletionPolicy controls what happens to Netbox objects of removed services
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDestroy deletes the Netbox objects
+	DeletionPolicyDestroy DeletionPolicy = "Destroy"
+	// DeletionPolicyRetain stops tracking the Netbox objects and leaves them untouched
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyDeprecate sets the status of the Netbox objects to
+	// deprecated and keeps tracking them
+	DeletionPolicyDeprecate DeletionPolicy = "Deprecate"
+	// DeletionPolicyTag adds the removed tag to the Netbox objects and keeps
+	// tracking them
+	DeletionPolicyTag DeletionPolicy = "Tag"
+)
+
+// ConditionSynced reports whether the last sync of a policy succeeded
+const ConditionSynced = "Synced"
+
+// NetboxSyncPolicy selects Kubernetes services and describes the Netbox
+// objects created for them
+type NetboxSyncPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NetboxSyncPolicySpec   `json:"spec"`
+	Status NetboxSyncPolicyStatus `json:"status,omitempty"`
+}
+
+type NetboxSyncPolicySpec struct {
+	// Cluster is the cluster name written to Netbox, defaults to KUBERNETES_CLUSTER
+	Cluster        string         `json:"cluster,omitempty"`
+	Source         SourceSpec     `json:"source,omitempty"`
+	Netbox         NetboxSpec     `json:"netbox,omitempty"`
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// SourceSpec selects the services to sync. Unset fields select everything.
+type SourceSpec struct {
+	NamespaceSelector  *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	Namespaces         []string              `json:"namespaces,omitempty"`
+	NamespacePatterns  []string              `json:"namespacePatterns,omitempty"`
+	NamespaceRegexes   []string              `json:"namespaceRegexes,omitempty"`
+	ExcludeNamespaces  []string              `json:"excludeNamespaces,omitempty"`
+	ServiceTypes       []string              `json:"serviceTypes,omitempty"`
+	ServiceLabels      map[string]string     `json:"serviceLabels,omitempty"`
+	ServiceAnnotations map[string]string     `json:"serviceAnnotations,omitempty"`
+}
+
+// NetboxSpec describes the attributes of the Netbox objects
+type NetboxSpec struct {
+	CustomFields map[string]string `json:"customFields,omitempty"`
+}
+
+type NetboxSyncPolicyStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	LastSyncTime       *metav1.Time       `json:"lastSyncTime,omitempty"`
+	ObjectCount        int32              `json:"objectCount"`
+	Errors             []string           `json:"errors,omitempty"`
+}
@@ -0,0 +1,182 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// key of the journal in the journal ConfigMap
+const configMapKey = "journal.jsonl"
+
+// maxConfigMapJournalSize caps the encoded journal, leaving room for the rest
+// of the ConfigMap under the 1 MiB object size limit of Kubernetes
+const maxConfigMapJournalSize = 768 << 10
+
+// ConfigMapJournal keeps the latest maxEntries entries in a ConfigMap, one
+// JSON object per line, within maxConfigMapJournalSize bytes. Concurrent
+// appends are written together in a single update, which is retried on
+// conflicts so that concurrent writers never lose entries.
+type ConfigMapJournal struct {
+	client     kubernetes.Interface
+	namespace  string
+	name       string
+	maxEntries int
+	maxSize    int
+
+	mu      sync.Mutex
+	writing bool
+	queue   []pendingAppend
+}
+
+// pendingAppend holds the entries of an Append waiting for the next write
+type pendingAppend struct {
+	entries []Entry
+	done    chan error
+}
+
+func NewConfigMapJournal(client kubernetes.Interface, namespace string, name string, maxEntries int) *ConfigMapJournal {
+	return &ConfigMapJournal{
+		client:     client,
+		namespace:  namespace,
+		name:       name,
+		maxEntries: maxEntries,
+		maxSize:    maxConfigMapJournalSize,
+	}
+}
+
+func (j *ConfigMapJournal) String() string {
+	return fmt.Sprintf("ConfigMap %s/%s", j.namespace, j.name)
+}
+
+// Append adds the entries to the ConfigMap and returns once they are written.
+// The first caller writes its entries along with those queued by the callers
+// arriving meanwhile, until the queue is empty.
+func (j *ConfigMapJournal) Append(ctx context.Context, entries []Entry) error {
+	pending := pendingAppend{entries: entries, done: make(chan error, 1)}
+
+	j.mu.Lock()
+	j.queue = append(j.queue, pending)
+	if j.writing {
+		j.mu.Unlock()
+		return <-pending.done
+	}
+	j.writing = true
+
+	for len(j.queue) > 0 {
+		batch := j.queue
+		j.queue = nil
+		j.mu.Unlock()
+
+		var all []Entry
+		for _, queued := range batch {
+			all = append(all, queued.entries...)
+		}
+		err := j.write(ctx, all)
+		for _, queued := range batch {
+			queued.done <- err
+		}
+
+		j.mu.Lock()
+	}
+	j.writing = false
+	j.mu.Unlock()
+
+	return <-pending.done
+}
+
+// write adds the entries to the ConfigMap, creating it when missing and
+// dropping the oldest entries beyond maxEntries and maxSize
+func (j *ConfigMapJournal) write(ctx context.Context, entries []Entry) error {
+	return retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+	}, func() error {
+		configMap, err := j.client.CoreV1().ConfigMaps(j.namespace).Get(ctx, j.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			data, err := j.trim(nil, entries)
+			if err != nil {
+				return err
+			}
+			_, err = j.client.CoreV1().ConfigMaps(j.namespace).Create(ctx, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      j.name,
+					Namespace: j.namespace,
+				},
+				Data: map[string]string{
+					configMapKey: string(data),
+				},
+			}, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		existing, err := decodeEntries([]byte(configMap.Data[configMapKey]), Query{})
+		if err != nil {
+			return fmt.Errorf("invalid journal in %s: %v", j, err)
+		}
+		data, err := j.trim(existing, entries)
+		if err != nil {
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[configMapKey] = string(data)
+		_, err = j.client.CoreV1().ConfigMaps(j.namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// trim encodes the existing and new entries, keeping the latest maxEntries
+// that fit in maxSize bytes
+func (j *ConfigMapJournal) trim(existing []Entry, entries []Entry) ([]byte, error) {
+	all := append(existing, entries...)
+	if len(all) > j.maxEntries {
+		all = all[len(all)-j.maxEntries:]
+	}
+
+	lines := make([][]byte, len(all))
+	size := 0
+	for i, entry := range all {
+		line, err := encodeEntries([]Entry{entry})
+		if err != nil {
+			return nil, err
+		}
+		lines[i] = line
+		size += len(line)
+	}
+	for len(lines) > 0 && size > j.maxSize {
+		size -= len(lines[0])
+		lines = lines[1:]
+	}
+
+	return bytes.Join(lines, nil), nil
+}
+
+// Read returns the entries of the ConfigMap matching the query, a missing
+// ConfigMap is an empty journal
+func (j *ConfigMapJournal) Read(ctx context.Context, query Query) ([]Entry, error) {
+	configMap, err := j.client.CoreV1().ConfigMaps(j.namespace).Get(ctx, j.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := decodeEntries([]byte(configMap.Data[configMapKey]), query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid journal in %s: %v", j, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,211 @@
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestConfigMapJournal(t *testing.T) {
+	entries := testEntries()
+	journal := NewConfigMapJournal(fake.NewSimpleClientset(), "default", "audit", 10)
+
+	result, err := journal.Read(t.Context(), Query{})
+	if err != nil || result != nil {
+		t.Fatalf("Read of a missing ConfigMap = %v, %v, expected nothing", result, err)
+	}
+
+	if err := journal.Append(t.Context(), entries[:2]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := journal.Append(t.Context(), entries[2:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err = journal.Read(t.Context(), Query{Service: "web"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []Entry{entries[0], entries[2]}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Read = %v, expected %v", result, expected)
+	}
+}
+
+func TestConfigMapJournalConflict(t *testing.T) {
+	entries := testEntries()
+	clientset := fake.NewSimpleClientset()
+	journal := NewConfigMapJournal(clientset, "default", "audit", 10)
+	if err := journal.Append(t.Context(), entries[:1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// another writer appends between the read and the update of this one
+	conflicted := false
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicted {
+			return false, nil, nil
+		}
+		conflicted = true
+
+		object, err := clientset.Tracker().Get(v1.SchemeGroupVersion.WithResource("configmaps"), "default", "audit")
+		if err != nil {
+			return true, nil, err
+		}
+		configMap := object.(*v1.ConfigMap)
+		data, err := encodeEntries(entries[1:2])
+		if err != nil {
+			return true, nil, err
+		}
+		configMap.Data[configMapKey] += string(data)
+		err = clientset.Tracker().Update(v1.SchemeGroupVersion.WithResource("configmaps"), configMap, "default")
+		if err != nil {
+			return true, nil, err
+		}
+		return true, nil, errors.NewConflict(v1.Resource("configmaps"), "audit", fmt.Errorf("stale resourceVersion"))
+	})
+
+	if err := journal.Append(t.Context(), entries[2:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := journal.Read(t.Context(), Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, entries) {
+		t.Errorf("Read = %v, expected every entry %v", result, entries)
+	}
+}
+
+func TestConfigMapJournalMaxEntries(t *testing.T) {
+	entries := testEntries()
+	journal := NewConfigMapJournal(fake.NewSimpleClientset(), "default", "audit", 2)
+
+	for _, entry := range entries {
+		if err := journal.Append(t.Context(), []Entry{entry}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	result, err := journal.Read(t.Context(), Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := entries[1:]; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Read = %v, expected the latest entries %v", result, expected)
+	}
+}
+
+func TestConfigMapJournalMaxSize(t *testing.T) {
+	entries := testEntries()
+	journal := NewConfigMapJournal(fake.NewSimpleClientset(), "default", "audit", 10)
+	latest, err := encodeEntries(entries[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.maxSize = len(latest)
+
+	if err := journal.Append(t.Context(), entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := journal.Read(t.Context(), Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := entries[1:]; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Read = %v, expected the latest entries %v", result, expected)
+	}
+}
+
+func TestConfigMapJournalConcurrentAppends(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	journal := NewConfigMapJournal(clientset, "default", "audit", 100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entry := testEntries()[0]
+			entry.RunID = fmt.Sprintf("run-%d", i)
+			if err := journal.Append(t.Context(), []Entry{entry}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	result, err := journal.Read(t.Context(), Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 50 {
+		t.Errorf("Read returned %d entries, expected 50", len(result))
+	}
+
+}
+
+func TestConfigMapJournalBatching(t *testing.T) {
+	entries := testEntries()
+	clientset := fake.NewSimpleClientset()
+	journal := NewConfigMapJournal(clientset, "default", "audit", 10)
+
+	// hold the first write until the other appends are queued
+	started, release := make(chan struct{}), make(chan struct{})
+	clientset.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		close(started)
+		<-release
+		return false, nil, nil
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(len(entries))
+	go func() {
+		defer wg.Done()
+		if err := journal.Append(t.Context(), entries[:1]); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+	<-started
+	for _, entry := range entries[1:] {
+		go func() {
+			defer wg.Done()
+			if err := journal.Append(t.Context(), []Entry{entry}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	for queued := 0; queued < len(entries)-1; {
+		journal.mu.Lock()
+		queued = len(journal.queue)
+		journal.mu.Unlock()
+	}
+	close(release)
+	wg.Wait()
+
+	var writes []string
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() != "get" {
+			writes = append(writes, action.GetVerb())
+		}
+	}
+	if expected := []string{"create", "update"}; !reflect.DeepEqual(writes, expected) {
+		t.Errorf("journal wrote %v, expected the queued appends in a single update %v", writes, expected)
+	}
+
+	result, err := journal.Read(t.Context(), Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != len(entries) {
+		t.Errorf("Read returned %d entries, expected %d", len(result), len(entries))
+	}
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileJournal appends the entries to a local file, one JSON object per line,
+// for running the syncer outside of a cluster. Each append is a single write,
+// appends of the same journal are serialized but other processes writing the
+// file are not otherwise coordinated.
+type FileJournal struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileJournal(path string) *FileJournal {
+	return &FileJournal{
+		path: path,
+	}
+}
+
+func (j *FileJournal) String() string {
+	return fmt.Sprintf("file %s", j.path)
+}
+
+// Append writes the entries at the end of the file, creating it when missing
+func (j *FileJournal) Append(ctx context.Context, entries []Entry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Read returns the entries of the file matching the query, a missing file is
+// an empty journal
+func (j *FileJournal) Read(ctx context.Context, query Query) ([]Entry, error) {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entries, err := decodeEntries(data, query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid journal in %s: %v", j, err)
+	}
+	return entries, nil
+}
+
+// encodeEntries encodes the entries as JSON lines
+func encodeEntries(entries []Entry) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// decodeEntries decodes JSON lines, keeping the entries matching the query
+func decodeEntries(data []byte, query Query) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if query.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestFileJournal(t *testing.T) {
+	entries := testEntries()
+	journal := NewFileJournal(filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	result, err := journal.Read(t.Context(), Query{})
+	if err != nil || result != nil {
+		t.Fatalf("Read of a missing file = %v, %v, expected nothing", result, err)
+	}
+
+	if err := journal.Append(t.Context(), entries[:2]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := journal.Append(t.Context(), entries[2:]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err = journal.Read(t.Context(), Query{Service: "web"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []Entry{entries[0], entries[2]}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Read = %v, expected %v", result, expected)
+	}
+}
+
+func TestFileJournalConcurrentAppends(t *testing.T) {
+	journal := NewFileJournal(filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entry := testEntries()[0]
+			entry.RunID = fmt.Sprintf("run-%d", i)
+			if err := journal.Append(t.Context(), []Entry{entry}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	result, err := journal.Read(t.Context(), Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 50 {
+		t.Errorf("Read returned %d entries, expected 50", len(result))
+	}
+}
+
+func TestFileJournalInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte("not json\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFileJournal(path).Read(t.Context(), Query{}); err == nil {
+		t.Error("Read of an invalid journal expected error but got none")
+	}
+}
@@ -0,0 +1,90 @@
+// Package audit journals every change the syncer makes to Netbox, an audit
+// trail independent of the Netbox changelog.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+)
+
+// Entry is a change made, or attempted, on a Netbox object. Time is when the
+// change completed. Before and After
+// are the records of the object before and after the change, After is unset
+// when the object was deleted or the change failed.
+type Entry struct {
+	Time      time.Time     `json:"time"`
+	RunID     string        `json:"run_id"`
+	Cluster   string        `json:"cluster"`
+	Namespace string        `json:"namespace,omitempty"`
+	Service   string        `json:"service,omitempty"`
+	IP        string        `json:"ip,omitempty"`
+	Prefix    string        `json:"prefix,omitempty"`
+	ObjectID  int32         `json:"object_id,omitempty"`
+	Action    string        `json:"action"`
+	Before    *model.Prefix `json:"before,omitempty"`
+	After     *model.Prefix `json:"after,omitempty"`
+	Outcome   string        `json:"outcome"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Query selects journal entries. Service is a service name or
+// namespace/name, IP an external IP or hostname or the address of a prefix.
+// Empty fields match every entry.
+type Query struct {
+	Service string
+	IP      string
+}
+
+// Matches tells whether the entry is selected by the query
+func (q Query) Matches(entry Entry) bool {
+	if q.Service != "" {
+		namespace, name, scoped := strings.Cut(q.Service, "/")
+		if !scoped {
+			namespace, name = "", q.Service
+		}
+		if entry.Service != name || (scoped && entry.Namespace != namespace) {
+			return false
+		}
+	}
+
+	if q.IP != "" {
+		address, _, _ := strings.Cut(entry.Prefix, "/")
+		if entry.IP != q.IP && address != q.IP {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Journal appends entries to a durable journal and reads them back
+type Journal interface {
+	// Append adds the entries at the end of the journal
+	Append(ctx context.Context, entries []Entry) error
+	// Read returns the entries matching the query, oldest first
+	Read(ctx context.Context, query Query) ([]Entry, error)
+}
+
+// NewJournal returns the journal selected by AUDIT_JOURNAL, nil when
+// journaling is disabled
+func NewJournal(setting settings.Settings, kubernetesClient *client.KubernetesClient, netboxClient *client.NetboxClient) (Journal, error) {
+	switch setting.AuditJournal {
+	case settings.AuditJournalNone:
+		return nil, nil
+	case settings.AuditJournalFile:
+		return NewFileJournal(setting.AuditFile), nil
+	case settings.AuditJournalConfigMap:
+		return NewConfigMapJournal(kubernetesClient.Client(), setting.KubernetesConfigMapNamepace, setting.AuditConfigMapName, setting.AuditConfigMapMaxEntries), nil
+	case settings.AuditJournalNetbox:
+		fallback := NewConfigMapJournal(kubernetesClient.Client(), setting.KubernetesConfigMapNamepace, setting.AuditConfigMapName, setting.AuditConfigMapMaxEntries)
+		return NewNetboxJournal(netboxClient, fallback), nil
+	default:
+		return nil, fmt.Errorf("unknown audit journal %q", setting.AuditJournal)
+	}
+}
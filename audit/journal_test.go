@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+)
+
+func testEntries() []Entry {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	prefix := model.Prefix{PrefixID: 7, Prefix: "10.0.0.1/32", ExternalIPs: "10.0.0.1", ServiceName: "web", Namespace: "default", Cluster: "prod-a"}
+	return []Entry{
+		{Time: at, RunID: "run-1", Cluster: "prod-a", Namespace: "default", Service: "web", IP: "10.0.0.1", Prefix: "10.0.0.1/32", ObjectID: 7, Action: "create", After: &prefix, Outcome: "succeeded"},
+		{Time: at, RunID: "run-1", Cluster: "prod-a", Namespace: "edge", Service: "api", IP: "lb-1.example.com", Prefix: "10.1.0.7/32", ObjectID: 8, Action: "delete", Outcome: "failed", Error: "timeout"},
+		{Time: at, RunID: "run-2", Cluster: "prod-a", Namespace: "default", Service: "web", IP: "10.0.0.1", Prefix: "10.0.0.1/32", ObjectID: 7, Action: "delete", Before: &prefix, Outcome: "succeeded"},
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	entries := testEntries()
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected []Entry
+	}{
+		{"Everything", Query{}, entries},
+		{"Service name", Query{Service: "web"}, []Entry{entries[0], entries[2]}},
+		{"Namespaced service", Query{Service: "edge/api"}, []Entry{entries[1]}},
+		{"Service in another namespace", Query{Service: "default/api"}, nil},
+		{"External IP", Query{IP: "10.0.0.1"}, []Entry{entries[0], entries[2]}},
+		{"External hostname", Query{IP: "lb-1.example.com"}, []Entry{entries[1]}},
+		{"Prefix address", Query{IP: "10.1.0.7"}, []Entry{entries[1]}},
+		{"Service and IP", Query{Service: "web", IP: "10.1.0.7"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result []Entry
+			for _, entry := range entries {
+				if tt.query.Matches(entry) {
+					result = append(result, entry)
+				}
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Matches selected %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestJournalComments(t *testing.T) {
+	for _, entry := range testEntries() {
+		comments, err := journalComments(entry)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		result, ok := parseJournalComments(comments)
+		if !ok || !reflect.DeepEqual(result, entry) {
+			t.Errorf("parseJournalComments(%q) = %v, %t, expected %v", comments, result, ok, entry)
+		}
+	}
+
+	if _, ok := parseJournalComments("Checked by the network team"); ok {
+		t.Error("parseJournalComments parsed a journal entry not written by the syncer")
+	}
+}
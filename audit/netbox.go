@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/report"
+)
+
+// journalMarker starts the comments of the journal entries written by the
+// syncer, the entry follows as a JSON code block
+const journalMarker = "kubernetes-service-netbox-syncer audit"
+
+// NetboxJournal writes the entries as Netbox journal entries on the affected
+// prefixes. Netbox deletes the journal of a prefix along with it, and a
+// failed creation has no prefix to journal on, those entries go to the
+// fallback journal instead.
+type NetboxJournal struct {
+	netboxClient *client.NetboxClient
+	fallback     Journal
+}
+
+func NewNetboxJournal(netboxClient *client.NetboxClient, fallback Journal) *NetboxJournal {
+	return &NetboxJournal{
+		netboxClient: netboxClient,
+		fallback:     fallback,
+	}
+}
+
+func (j *NetboxJournal) String() string {
+	return "Netbox journal"
+}
+
+// Append adds a journal entry to the prefix of every entry and the entries
+// without a prefix left in Netbox to the fallback journal. An entry that
+// cannot be written does not hold back the others.
+func (j *NetboxJournal) Append(ctx context.Context, entries []Entry) error {
+	var errs []error
+	var orphaned []Entry
+
+	for _, entry := range entries {
+		if !hasNetboxObject(entry) {
+			orphaned = append(orphaned, entry)
+			continue
+		}
+
+		comments, err := journalComments(entry)
+		if err == nil {
+			err = j.netboxClient.CreateJournalEntry(ctx, entry.ObjectID, entry.Outcome != report.OutcomeSucceeded, comments)
+		}
+		if err != nil {
+			slog.Warn("Failed to journal change in Netbox", logging.KeyCluster, entry.Cluster, logging.KeyNamespace, entry.Namespace, logging.KeyService, entry.Service, logging.KeyNetboxID, entry.ObjectID, "action", entry.Action, logging.KeyError, err)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(orphaned) > 0 {
+		if err := j.fallback.Append(ctx, orphaned); err != nil {
+			errs = append(errs, fmt.Errorf("failed to journal changes without a Netbox object: %v", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Read returns the journal entries written by the syncer matching the query,
+// merged with the ones of the fallback journal
+func (j *NetboxJournal) Read(ctx context.Context, query Query) ([]Entry, error) {
+	comments, err := j.netboxClient.ListJournalEntries(ctx, journalMarker)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, comment := range comments {
+		entry, ok := parseJournalComments(comment)
+		if ok && query.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	orphaned, err := j.fallback.Read(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	entries = append(entries, orphaned...)
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		return a.Time.Compare(b.Time)
+	})
+	return entries, nil
+}
+
+// hasNetboxObject tells whether the object of an entry is still in Netbox to
+// hold its journal, it is not once deleted or when its creation failed
+func hasNetboxObject(entry Entry) bool {
+	if entry.ObjectID == 0 {
+		return false
+	}
+	return entry.Action != report.OperationDelete || entry.Outcome != report.OutcomeSucceeded
+}
+
+// journalComments returns the comments of the Netbox journal entry of an
+// entry, a summary line followed by the entry as JSON
+func journalComments(entry Entry) (string, error) {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s: %s %s\n\n```json\n%s\n```", journalMarker, entry.Action, entry.Outcome, data), nil
+}
+
+// parseJournalComments returns the entry of comments written by
+// journalComments, false for any other journal entry
+func parseJournalComments(comments string) (Entry, bool) {
+	var entry Entry
+
+	if !strings.HasPrefix(comments, journalMarker+":") {
+		return entry, false
+	}
+	_, data, found := strings.Cut(comments, "```json\n")
+	if !found {
+		return entry, false
+	}
+	data, _, found = strings.Cut(data, "\n```")
+	if !found {
+		return entry, false
+	}
+
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
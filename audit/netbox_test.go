@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNetboxJournal(t *testing.T) {
+	var journaled []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/extras/journal-entries/" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]any{"count": len(journaled), "results": journaled})
+			return
+		}
+
+		var request map[string]any
+		json.NewDecoder(r.Body).Decode(&request)
+		if request["assigned_object_id"] == 9.0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		entry := map[string]any{
+			"id":                   len(journaled) + 1,
+			"url":                  "http://netbox/api/extras/journal-entries/1/",
+			"display":              "journal entry",
+			"assigned_object_type": request["assigned_object_type"],
+			"assigned_object_id":   request["assigned_object_id"],
+			"kind":                 map[string]any{"value": request["kind"], "label": "Info"},
+			"comments":             request["comments"],
+		}
+		journaled = append(journaled, entry)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(entry)
+	}))
+	defer server.Close()
+
+	netboxClient, err := client.NewNetboxClient(settings.Settings{
+		NetboxURL:           server.URL,
+		NetboxAPIToken:      "token",
+		NetboxRetryAttempts: 1,
+		APITimeout:          time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback := NewConfigMapJournal(fake.NewSimpleClientset(), "default", "audit", 10)
+	journal := NewNetboxJournal(netboxClient, fallback)
+
+	entries := testEntries()
+	failedCreate := Entry{Time: entries[2].Time.Add(time.Second), RunID: "run-2", Cluster: "prod-a", Namespace: "default", Service: "web", IP: "10.0.0.1", Action: "create", Outcome: "failed", Error: "timeout"}
+	unwritable := Entry{Time: entries[2].Time, RunID: "run-2", Cluster: "prod-a", Namespace: "default", Service: "db", Prefix: "10.0.0.9/32", ObjectID: 9, Action: "deprecate", Outcome: "succeeded"}
+
+	err = journal.Append(t.Context(), []Entry{entries[0], unwritable, entries[1], entries[2], failedCreate})
+	if err == nil {
+		t.Error("Append expected the error of the unwritable entry but got none")
+	}
+
+	// the creation and the failed deletion are journaled on their prefixes,
+	// the deleted prefix and the failed creation have none
+	if len(journaled) != 2 {
+		t.Errorf("got %d Netbox journal entries, expected 2", len(journaled))
+	}
+	orphaned, err := fallback.Read(t.Context(), Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []Entry{entries[2], failedCreate}; !reflect.DeepEqual(orphaned, expected) {
+		t.Errorf("fallback journal = %v, expected %v", orphaned, expected)
+	}
+
+	result, err := journal.Read(t.Context(), Query{Service: "web"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []Entry{entries[0], entries[2], failedCreate}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("Read = %v, expected %v", result, expected)
+	}
+}
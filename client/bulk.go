@@ -0,0 +1,167 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/netbox-community/go-netbox/v4"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+)
+
+// prefixesPath is the Netbox endpoint of prefixes, which takes a list of
+// prefixes to create or delete them in bulk
+const prefixesPath = "/api/ipam/prefixes/"
+
+// ErrUncertain is wrapped by the errors of requests Netbox may have applied
+// without the syncer knowing: the request was sent but timed out, or Netbox
+// answered with a server error
+var ErrUncertain = errors.New("outcome unknown")
+
+// createdPrefix is the part of a prefix returned by a bulk create the state
+// records
+type createdPrefix struct {
+	ID          int32     `json:"id"`
+	Created     time.Time `json:"created"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+// CreatePrefixes creates the prefixes of several services with a single bulk
+// request and returns them in the order of the services. Netbox creates all
+// of them or none.
+func (c *NetboxClient) CreatePrefixes(ctx context.Context, services []model.KubernetesService) ([][]model.Prefix, error) {
+	var requests []netbox.WritablePrefixRequest
+	var owners []int
+	for i, service := range services {
+		serviceRequests, err := c.prefixRequests(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, serviceRequests...)
+		for range serviceRequests {
+			owners = append(owners, i)
+		}
+	}
+
+	prefixes := make([][]model.Prefix, len(services))
+	if len(requests) == 0 {
+		return prefixes, nil
+	}
+
+	var created []createdPrefix
+	err := c.bulkCreate(ctx, requests, &created)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create prefixes in Netbox: %w", err)
+	}
+	if len(created) != len(requests) {
+		return nil, fmt.Errorf("failed to create prefixes in Netbox: %d of %d prefixes returned", len(created), len(requests))
+	}
+
+	for i, request := range requests {
+		service := services[owners[i]]
+		prefixes[owners[i]] = append(prefixes[owners[i]], c.newPrefix(service, request.Prefix, created[i].ID, created[i].Created, created[i].LastUpdated))
+	}
+	return prefixes, nil
+}
+
+// DeletePrefixes deletes several prefixes with a single bulk request. Netbox
+// deletes all of them or none, a 404 means none of them exists anymore.
+func (c *NetboxClient) DeletePrefixes(ctx context.Context, prefixes []model.Prefix) error {
+	requests := make([]netbox.PrefixRequest, len(prefixes))
+	for i, prefix := range prefixes {
+		requests[i] = netbox.PrefixRequest{
+			Prefix:               prefix.Prefix,
+			AdditionalProperties: map[string]interface{}{"id": prefix.PrefixID},
+		}
+	}
+
+	response, err := c.netboxClient.IpamAPI.IpamPrefixesBulkDestroy(ctx).PrefixRequest(requests).Execute()
+	if err != nil && response != nil && response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete prefixes from Netbox: %v", apiError(err))
+	}
+	return nil
+}
+
+// FindPrefixes returns the prefixes of a service that already exist in
+// Netbox, found by the description CreatePrefix gives each of them. It tells
+// whether a creation that failed with ErrUncertain went through.
+func (c *NetboxClient) FindPrefixes(ctx context.Context, service model.KubernetesService) ([]model.Prefix, error) {
+	requests, err := c.prefixRequests(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptions []string
+	for _, request := range requests {
+		descriptions = append(descriptions, request.GetDescription())
+	}
+
+	list, _, err := c.netboxClient.IpamAPI.IpamPrefixesList(ctx).
+		Description(descriptions).
+		Limit(listPageSize).
+		Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prefixes in Netbox: %v", apiError(err))
+	}
+
+	prefixes := []model.Prefix{}
+	for _, prefix := range list.Results {
+		prefixes = append(prefixes, c.newPrefix(service, prefix.Prefix, prefix.Id, prefix.GetCreated(), prefix.GetLastUpdated()))
+	}
+	return prefixes, nil
+}
+
+// bulkCreate posts a list of prefixes to the prefixes endpoint. go-netbox has
+// no bulk create, so the request is built here the way the generated
+// IpamPrefixesCreate builds it: same base path, headers and HTTP client.
+func (c *NetboxClient) bulkCreate(ctx context.Context, requests []netbox.WritablePrefixRequest, out any) error {
+	body, err := json.Marshal(requests)
+	if err != nil {
+		return err
+	}
+
+	config := c.netboxClient.GetConfig()
+	basePath, err := config.ServerURLWithContext(ctx, "IpamAPIService.IpamPrefixesCreate")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, basePath+prefixesPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range config.DefaultHeader {
+		request.Header.Set(key, value)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("User-Agent", config.UserAgent)
+
+	response, err := config.HTTPClient.Do(request)
+	if err != nil {
+		if notSent(err) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrUncertain, err)
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: %s: %s", ErrUncertain, response.Status, bytes.TrimSpace(data))
+	}
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s: %s", response.Status, bytes.TrimSpace(data))
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+)
+
+func newTestNetboxClient(t *testing.T, handler http.HandlerFunc) *NetboxClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := NewNetboxClient(settings.Settings{
+		NetboxURL:           server.URL,
+		NetboxAPIToken:      "token",
+		NetboxRetryAttempts: 1,
+		NetboxRetryBackoff:  time.Millisecond,
+		APITimeout:          time.Second,
+		KubernetesCluster:   "cluster-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestCreatePrefixes(t *testing.T) {
+	services := []model.KubernetesService{
+		{Name: "nginx", Namespace: "default", ExternalIPs: "10.0.0.1"},
+		{Name: "redis", Namespace: "cache", ExternalIPs: "10.0.0.2"},
+	}
+
+	client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != prefixesPath {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Token token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+
+		var requests []map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			t.Fatal(err)
+		}
+
+		var created []map[string]any
+		for i, request := range requests {
+			created = append(created, map[string]any{"id": 100 + i, "prefix": request["prefix"]})
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	})
+
+	prefixes, err := client.CreatePrefixes(t.Context(), services)
+	if err != nil {
+		t.Fatalf("CreatePrefixes unexpected error: %v", err)
+	}
+
+	if len(prefixes) != len(services) {
+		t.Fatalf("got prefixes of %d services, expected %d", len(prefixes), len(services))
+	}
+	for i, service := range services {
+		if len(prefixes[i]) != 1 {
+			t.Fatalf("service %s got %d prefixes, expected 1", service.Name, len(prefixes[i]))
+		}
+		prefix := prefixes[i][0]
+		if prefix.PrefixID != int32(100+i) || prefix.Prefix != service.ExternalIPs+"/32" || prefix.ServiceName != service.Name {
+			t.Errorf("service %s got prefix %+v", service.Name, prefix)
+		}
+	}
+}
+
+func TestCreatePrefixesError(t *testing.T) {
+	client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`[{}, {"prefix": ["Duplicate prefix found"]}]`))
+	})
+
+	_, err := client.CreatePrefixes(t.Context(), []model.KubernetesService{
+		{Name: "nginx", Namespace: "default", ExternalIPs: "10.0.0.1"},
+		{Name: "redis", Namespace: "cache", ExternalIPs: "10.0.0.2"},
+	})
+	if err == nil {
+		t.Fatal("CreatePrefixes expected error but got none")
+	}
+	if !strings.Contains(err.Error(), "Duplicate prefix found") {
+		t.Errorf("error %q does not contain the Netbox error body", err)
+	}
+	if errors.Is(err, ErrUncertain) {
+		t.Errorf("error %q of a rejected request is uncertain", err)
+	}
+}
+
+func TestCreatePrefixesUncertain(t *testing.T) {
+	client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := client.CreatePrefixes(t.Context(), []model.KubernetesService{
+		{Name: "nginx", Namespace: "default", ExternalIPs: "10.0.0.1"},
+		{Name: "redis", Namespace: "cache", ExternalIPs: "10.0.0.2"},
+	})
+	if !errors.Is(err, ErrUncertain) {
+		t.Errorf("CreatePrefixes error = %v, expected an uncertain outcome", err)
+	}
+}
+
+func TestFindPrefixes(t *testing.T) {
+	client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != prefixesPath {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if description := r.URL.Query().Get("description"); description != "10.0.0.1-nginx-default-cluster-1" {
+			t.Errorf("description filter = %q", description)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"count": 1,
+			"results": []map[string]any{{
+				"id":       7,
+				"url":      "http://netbox/api/ipam/prefixes/7/",
+				"display":  "10.0.0.1/32",
+				"family":   map[string]any{"value": 4, "label": "IPv4"},
+				"prefix":   "10.0.0.1/32",
+				"children": 0,
+				"_depth":   0,
+			}},
+		})
+	})
+
+	prefixes, err := client.FindPrefixes(t.Context(), model.KubernetesService{Name: "nginx", Namespace: "default", ExternalIPs: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("FindPrefixes unexpected error: %v", err)
+	}
+	if len(prefixes) != 1 || prefixes[0].PrefixID != 7 || prefixes[0].ServiceName != "nginx" {
+		t.Errorf("FindPrefixes = %+v, expected prefix 7 of nginx", prefixes)
+	}
+}
+
+func TestDeletePrefixes(t *testing.T) {
+	var body []map[string]any
+	client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.URL.Path != prefixesPath {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	prefixes := []model.Prefix{{PrefixID: 1, Prefix: "10.0.0.1/32"}, {PrefixID: 2, Prefix: "10.0.0.2/32"}}
+	if err := client.DeletePrefixes(t.Context(), prefixes); err != nil {
+		t.Fatalf("DeletePrefixes unexpected error: %v", err)
+	}
+
+	expected := []map[string]any{{"id": 1.0, "prefix": "10.0.0.1/32"}, {"id": 2.0, "prefix": "10.0.0.2/32"}}
+	if !reflect.DeepEqual(body, expected) {
+		t.Errorf("request body = %v, expected %v", body, expected)
+	}
+}
+
+func TestDeletePrefixesError(t *testing.T) {
+	client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"detail": "Unable to delete object, dependent objects were found"}`))
+	})
+
+	err := client.DeletePrefixes(t.Context(), []model.Prefix{{PrefixID: 1, Prefix: "10.0.0.1/32"}, {PrefixID: 2, Prefix: "10.0.0.2/32"}})
+	if err == nil {
+		t.Fatal("DeletePrefixes expected error but got none")
+	}
+	if !strings.Contains(err.Error(), "dependent objects were found") {
+		t.Errorf("error %q does not contain the Netbox error body", err)
+	}
+}
+
+func TestDeletePrefixesNotFound(t *testing.T) {
+	client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"detail": "Not found."}`))
+	})
+
+	err := client.DeletePrefixes(t.Context(), []model.Prefix{{PrefixID: 1, Prefix: "10.0.0.1/32"}, {PrefixID: 2, Prefix: "10.0.0.2/32"}})
+	if err != nil {
+		t.Errorf("DeletePrefixes of missing prefixes unexpected error: %v", err)
+	}
+}
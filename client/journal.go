@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/netbox-community/go-netbox/v4"
+)
+
+// prefixObjectType is the Netbox content type of prefixes, which journal
+// entries are assigned to
+const prefixObjectType = "ipam.prefix"
+
+// CreateJournalEntry adds a journal entry to a prefix. Failed changes are
+// journaled as warnings.
+func (c *NetboxClient) CreateJournalEntry(ctx context.Context, id int32, failed bool, comments string) error {
+	request := netbox.NewWritableJournalEntryRequest(prefixObjectType, int64(id), comments)
+	kind := netbox.JOURNALENTRYKINDVALUE_INFO
+	if failed {
+		kind = netbox.JOURNALENTRYKINDVALUE_WARNING
+	}
+	request.SetKind(kind)
+
+	_, _, err := c.netboxClient.ExtrasAPI.ExtrasJournalEntriesCreate(ctx).WritableJournalEntryRequest(*request).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to create journal entry on prefix %d in Netbox: %v", id, apiError(err))
+	}
+	return nil
+}
+
+// ListJournalEntries returns the comments of the prefix journal entries
+// containing search, oldest first
+func (c *NetboxClient) ListJournalEntries(ctx context.Context, search string) ([]string, error) {
+	var comments []string
+
+	for offset := int32(0); ; {
+		list, _, err := c.netboxClient.ExtrasAPI.ExtrasJournalEntriesList(ctx).
+			AssignedObjectType(prefixObjectType).
+			Q(search).
+			Ordering("created").
+			Limit(listPageSize).
+			Offset(offset).
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list journal entries in Netbox: %v", apiError(err))
+		}
+
+		for _, entry := range list.Results {
+			comments = append(comments, entry.Comments)
+		}
+
+		offset += int32(len(list.Results))
+		if len(list.Results) == 0 || offset >= list.Count {
+			break
+		}
+	}
+
+	return comments, nil
+}
@@ -3,86 +3,295 @@ package client
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
 	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
 	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/utils"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// SyncAnnotation opts a Service or Namespace in ("true") or out ("false") of syncing
+	SyncAnnotation = "netbox-syncer.io/sync"
+	// ProtectAnnotation set to "true" on a Service keeps its Netbox objects
+	// once the Service is removed
+	ProtectAnnotation = "netbox-syncer.io/protect"
+
+	// PrefixIDsAnnotation, PrefixURLsAnnotation and LastSyncedAnnotation are
+	// written back onto synced Services when KUBERNETES_SERVICE_WRITEBACK is enabled
+	PrefixIDsAnnotation  = "netbox-syncer.io/prefix-ids"
+	PrefixURLsAnnotation = "netbox-syncer.io/prefix-urls"
+	LastSyncedAnnotation = "netbox-syncer.io/last-synced"
+
+	// Event reasons recorded on Services
+	EventReasonPrefixCreated       = "PrefixCreated"
+	EventReasonPrefixDeleted       = "PrefixDeleted"
+	EventReasonPrefixCreateFailed  = "PrefixCreateFailed"
+	EventReasonPrefixDeleteFailed  = "PrefixDeleteFailed"
+	EventReasonPrefixDeprecated    = "PrefixDeprecated"
+	EventReasonPrefixTagged        = "PrefixTagged"
+	EventReasonPrefixRestored      = "PrefixRestored"
+	EventReasonPrefixProtected     = "PrefixProtected"
+	EventReasonDNSResolutionFailed = "DNSResolutionFailed"
+
+	// FieldManager owns the annotations written back with server-side apply
+	FieldManager = "kubernetes-service-netbox-syncer"
+)
+
+const (
+	// eventFlushTimeout bounds how long Shutdown waits for the recorded
+	// events to be written
+	eventFlushTimeout = 10 * time.Second
+	// maxQueuedEvents bounds the events waiting to be written, further
+	// events are dropped
+	maxQueuedEvents = 1000
 )
 
 type KubernetesClient struct {
-	k8sClient *kubernetes.Clientset
-	Settings  settings.Settings
+	k8sClient     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	events        *eventWriter
+	Settings      settings.Settings
 }
 
-func (c *KubernetesClient) Client() *kubernetes.Clientset {
+func (c *KubernetesClient) Client() kubernetes.Interface {
 	return c.k8sClient
 }
 
-func (c *KubernetesClient) GetKubernetesService() ([]model.KubernetesService, error) {
+// WithSettings returns a client sharing the connection and event writer of
+// c but filtering services according to settings
+func (c *KubernetesClient) WithSettings(settings settings.Settings) *KubernetesClient {
+	conf := *c
+	conf.Settings = settings
+	return &conf
+}
+
+func (c *KubernetesClient) GetKubernetesService(ctx context.Context) ([]model.KubernetesService, error) {
 	var kubernetesServices []model.KubernetesService
 
 	// Get namespaces to query
-	namespaces := c.Settings.KubernetesNamespaceFilter
-	if len(namespaces) == 0 {
-		// If no namespace filter, get all namespaces
-		namespaceList, err := c.k8sClient.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			return nil, err
+	namespaces, err := c.getNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := c.listServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, svc := range services {
+		if !c.matchesService(&svc, namespaces[svc.Namespace]) {
+			continue
 		}
-		for _, ns := range namespaceList.Items {
-			namespaces = append(namespaces, ns.Name)
+
+		// Get external IPs
+		externalIP := c.getExternalIP(&svc)
+		if externalIP == "" {
+			continue
 		}
+
+		kubernetesServices = append(kubernetesServices, model.KubernetesService{
+			UID:         string(svc.UID),
+			Name:        svc.Name,
+			Namespace:   svc.Namespace,
+			ExternalIPs: externalIP,
+			Protected:   protectAnnotation(svc.Annotations),
+		})
 	}
 
-	// Query services from each namespace
-	for _, namespace := range namespaces {
-		services, err := c.k8sClient.CoreV1().Services(namespace).List(context.Background(), metav1.ListOptions{})
+	return kubernetesServices, nil
+}
+
+// listServices lists the services of the named namespaces when only namespace
+// names are configured, otherwise of every namespace as the opt-in annotation
+// may select a service outside of the namespace filters
+func (c *KubernetesClient) listServices(ctx context.Context) ([]v1.Service, error) {
+	names := c.namedNamespaces()
+	if len(names) == 0 {
+		services, err := c.k8sClient.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
 		if err != nil {
-			log.Printf("failed to list services in namespace %s: %v", namespace, err)
+			return nil, err
+		}
+		return services.Items, nil
+	}
+
+	var services []v1.Service
+	listed := make(map[string]bool)
+	for _, name := range names {
+		if listed[name] {
 			continue
 		}
+		listed[name] = true
 
-		for _, svc := range services.Items {
-			// Filter by service type
-			if !c.matchesTypeFilter(svc.Spec.Type) {
-				continue
-			}
+		list, err := c.k8sClient.CoreV1().Services(name).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, list.Items...)
+	}
+	return services, nil
+}
 
-			// Filter by annotations
-			if !c.matchesAnnotationFilter(svc.Annotations) {
-				continue
-			}
+// namedNamespaces returns the namespace names when they are the only
+// namespace filter configured, nil otherwise
+func (c *KubernetesClient) namedNamespaces() []string {
+	if len(c.Settings.KubernetesNamespacePattern) > 0 || len(c.Settings.KubernetesNamespaceRegexps) > 0 || strings.TrimSpace(c.Settings.KubernetesNamespaceSelector) != "" {
+		return nil
+	}
+	return c.Settings.KubernetesNamespaceFilter
+}
 
-			// Filter by labels
-			if !c.matchesLabelFilter(svc.Labels) {
-				continue
-			}
+// ServiceProtected tells whether a service carries the protect annotation,
+// false when the service does not exist
+func (c *KubernetesClient) ServiceProtected(ctx context.Context, namespace string, name string) (bool, error) {
+	svc, err := c.k8sClient.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return protectAnnotation(svc.Annotations), nil
+}
+
+// matchesService checks if the service should be synced. The sync annotation
+// on the service takes precedence over everything else, then the namespace
+// selection, then the type, annotation and label filters.
+func (c *KubernetesClient) matchesService(svc *v1.Service, namespaceSelected bool) bool {
+	if sync, ok := syncAnnotation(svc.Annotations); ok {
+		return sync
+	}
+
+	if !namespaceSelected {
+		return false
+	}
+
+	// Filter by service type
+	if !c.matchesTypeFilter(svc.Spec.Type) {
+		return false
+	}
+
+	// Filter by annotations
+	if !c.matchesAnnotationFilter(svc.Annotations) {
+		return false
+	}
+
+	// Filter by labels
+	return c.matchesLabelFilter(svc.Labels)
+}
+
+// getNamespaces resolves the namespaces to query from the sync annotation,
+// the namespace selector, the name, glob and regex filters and the exclude
+// list. When only namespace names are configured, those namespaces are read
+// instead of listing every namespace of the cluster.
+func (c *KubernetesClient) getNamespaces(ctx context.Context) (map[string]bool, error) {
+	namespaces := make(map[string]bool)
+
+	selector, err := labels.Parse(c.Settings.KubernetesNamespaceSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	names := c.Settings.KubernetesNamespaceFilter
+	patterns := c.Settings.KubernetesNamespacePattern
+	regexes := c.Settings.KubernetesNamespaceRegexps
+	filtered := len(names) > 0 || len(patterns) > 0 || len(regexes) > 0
 
-			// Get external IPs
-			externalIP := c.getExternalIP(&svc)
-			if externalIP == "" {
+	var candidates []v1.Namespace
+	if named := c.namedNamespaces(); len(named) > 0 {
+		for _, name := range named {
+			ns, err := c.k8sClient.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
 				continue
 			}
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, *ns)
+		}
+	} else {
+		namespaceList, err := c.k8sClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		candidates = namespaceList.Items
+	}
 
-			kubernetesServices = append(kubernetesServices, model.KubernetesService{
-				Name:        svc.Name,
-				Namespace:   svc.Namespace,
-				ExternalIPs: externalIP,
-			})
+	for _, ns := range candidates {
+		if sync, ok := syncAnnotation(ns.Annotations); ok {
+			namespaces[ns.Name] = sync
+			continue
+		}
+
+		if !selector.Matches(labels.Set(ns.Labels)) {
+			continue
+		}
+
+		// If no name filter, accept every namespace matching the selector
+		if filtered && !utils.MatchName(ns.Name, names, patterns, regexes) {
+			continue
+		}
+
+		if utils.MatchName(ns.Name, nil, c.Settings.KubernetesNamespaceExclude, nil) {
+			continue
 		}
+
+		namespaces[ns.Name] = true
 	}
 
-	return kubernetesServices, nil
+	return namespaces, nil
+}
+
+// syncAnnotation returns the value of the sync annotation and whether it is set
+func syncAnnotation(annotations map[string]string) (bool, bool) {
+	value, ok := annotations[SyncAnnotation]
+	if !ok {
+		return false, false
+	}
+
+	sync, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid annotation value", "annotation", SyncAnnotation, "value", value)
+		return false, false
+	}
+	return sync, true
+}
+
+// protectAnnotation returns whether the protect annotation is set to true
+func protectAnnotation(annotations map[string]string) bool {
+	value, ok := annotations[ProtectAnnotation]
+	if !ok {
+		return false
+	}
+
+	protect, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid annotation value", "annotation", ProtectAnnotation, "value", value)
+		return false
+	}
+	return protect
 }
 
 // matchesTypeFilter checks if the service type matches the filter
@@ -151,120 +360,155 @@ func (c *KubernetesClient) getExternalIP(svc *v1.Service) string {
 	return ""
 }
 
-func (c *KubernetesClient) CreateOrLoadConfiMap() ([]model.Prefix, error) {
-	var prefixes []model.Prefix
+// RecordServiceEvent emits a Kubernetes Event on a service. The UID may be
+// empty when the service no longer exists, nothing is emitted without the
+// name of the service, like for an orphaned prefix.
+func (c *KubernetesClient) RecordServiceEvent(namespace string, name string, uid string, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if name == "" {
+		return
+	}
+	now := metav1.Now()
+	c.events.enqueue(&v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Namespace:  namespace,
+			Name:       name,
+			UID:        types.UID(uid),
+		},
+		Reason:              reason,
+		Message:             fmt.Sprintf(messageFmt, args...),
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		Type:                eventType,
+		Source:              v1.EventSource{Component: FieldManager},
+		ReportingController: FieldManager,
+	})
+}
 
-	configMapName := c.Settings.KubernetesConfigMapName
-	configMapNamespace := c.Settings.KubernetesConfigMapNamepace
+// Shutdown waits for the queued events to be written, for at most
+// eventFlushTimeout, then stops writing events. Events still queued after
+// that are lost.
+func (c *KubernetesClient) Shutdown() {
+	flushed := make(chan struct{})
+	go func() {
+		c.events.pending.Wait()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+	case <-time.After(eventFlushTimeout):
+		slog.Warn("Timed out writing Kubernetes events, the remaining ones are dropped", "timeout", eventFlushTimeout)
+	}
+	c.events.stopOnce.Do(func() { close(c.events.stop) })
+}
 
-	// Try to get existing ConfigMap
-	configMap, err := c.k8sClient.CoreV1().ConfigMaps(configMapNamespace).Get(
-		context.Background(),
-		configMapName,
-		metav1.GetOptions{},
-	)
+// eventWriter writes queued events to the Kubernetes API one at a time,
+// correlated like the record package does, and counts the events queued but
+// not written yet
+type eventWriter struct {
+	sink       record.EventSink
+	correlator *record.EventCorrelator
+	queue      chan *v1.Event
+	pending    sync.WaitGroup
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
 
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// ConfigMap not found, create it with empty data
-			newConfigMap := &v1.ConfigMap{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      configMapName,
-					Namespace: configMapNamespace,
-				},
-				Data: map[string]string{
-					"prefixes.json": "[]",
-				},
-			}
+func newEventWriter(sink record.EventSink) *eventWriter {
+	w := &eventWriter{
+		sink:       sink,
+		correlator: record.NewEventCorrelatorWithOptions(record.CorrelatorOptions{}),
+		queue:      make(chan *v1.Event, maxQueuedEvents),
+		stop:       make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
 
-			_, err = c.k8sClient.CoreV1().ConfigMaps(configMapNamespace).Create(
-				context.Background(),
-				newConfigMap,
-				metav1.CreateOptions{},
-			)
-			if err != nil {
-				return nil, err
-			}
-			log.Printf("Created new ConfigMap %s/%s with empty prefix list", configMapNamespace, configMapName)
-			return prefixes, nil
-		}
-		return nil, err
+// enqueue queues an event to be written, dropping it when maxQueuedEvents
+// are already waiting. Only queued events are counted as pending.
+func (w *eventWriter) enqueue(event *v1.Event) {
+	w.pending.Add(1)
+	select {
+	case w.queue <- event:
+	default:
+		w.pending.Done()
+		slog.Warn("Too many queued Kubernetes events, dropped event", logging.KeyNamespace, event.InvolvedObject.Namespace, logging.KeyService, event.InvolvedObject.Name, "reason", event.Reason)
 	}
+}
 
-	// ConfigMap exists, load the data
-	if data, ok := configMap.Data["prefixes.json"]; ok && data != "" {
-		err = json.Unmarshal([]byte(data), &prefixes)
-		if err != nil {
-			log.Printf("Failed to unmarshal prefixes from ConfigMap: %v", err)
-			return nil, err
+// run writes the queued events until the writer is stopped
+func (w *eventWriter) run() {
+	for {
+		select {
+		case event := <-w.queue:
+			w.write(event)
+		case <-w.stop:
+			return
 		}
-		log.Printf("Loaded %d prefixes from ConfigMap %s/%s", len(prefixes), configMapNamespace, configMapName)
-	} else {
-		log.Printf("ConfigMap %s/%s exists but has no prefix data", configMapNamespace, configMapName)
 	}
-
-	return prefixes, nil
 }
 
-func (c *KubernetesClient) SavePrefixToConfigMap(prefixes []model.Prefix) error {
-	// Marshal prefixes to JSON
-	data, err := json.MarshalIndent(prefixes, "", "  ")
+// write creates the event, or patches the event it is aggregated with. A
+// failed write is logged and not retried.
+func (w *eventWriter) write(event *v1.Event) {
+	defer w.pending.Done()
+
+	eventCopy := *event
+	result, err := w.correlator.EventCorrelate(&eventCopy)
 	if err != nil {
-		return err
+		slog.Warn("Failed to correlate Kubernetes event", logging.KeyError, err)
+	}
+	if result.Skip {
+		return
 	}
 
-	configMapName := c.Settings.KubernetesConfigMapName
-	configMapNamespace := c.Settings.KubernetesConfigMapNamepace
-
-	// Check if ConfigMap exists
-	existingConfigMap, err := c.k8sClient.CoreV1().ConfigMaps(configMapNamespace).Get(
-		context.Background(),
-		configMapName,
-		metav1.GetOptions{},
-	)
+	var written *v1.Event
+	update := result.Event.Count > 1
+	if update {
+		written, err = w.sink.Patch(result.Event, result.Patch)
+	}
+	if !update || errors.IsNotFound(err) {
+		result.Event.ResourceVersion = ""
+		written, err = w.sink.Create(result.Event)
+	}
+	if err != nil {
+		slog.Warn("Failed to write Kubernetes event", logging.KeyNamespace, event.InvolvedObject.Namespace, logging.KeyService, event.InvolvedObject.Name, "reason", event.Reason, logging.KeyError, err)
+		return
+	}
+	w.correlator.UpdateState(written)
+}
 
+// ApplyServiceAnnotations sets the written back annotations on a service with
+// server-side apply. Passing nil annotations releases every annotation
+// previously owned by the syncer. Services that no longer exist are skipped.
+func (c *KubernetesClient) ApplyServiceAnnotations(ctx context.Context, namespace string, name string, annotations map[string]string) error {
+	svc, err := c.k8sClient.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
-			// Create new ConfigMap
-			configMap := &v1.ConfigMap{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      configMapName,
-					Namespace: configMapNamespace,
-				},
-				Data: map[string]string{
-					"prefixes.json": string(data),
-				},
-			}
-
-			_, err = c.k8sClient.CoreV1().ConfigMaps(configMapNamespace).Create(
-				context.Background(),
-				configMap,
-				metav1.CreateOptions{},
-			)
-			if err != nil {
-				return err
-			}
-			log.Printf("Created ConfigMap %s/%s", configMapNamespace, configMapName)
 			return nil
 		}
 		return err
 	}
 
-	// Update existing ConfigMap
-	existingConfigMap.Data = map[string]string{
-		"prefixes.json": string(data),
+	// The UID guards against creating or patching a recreated service
+	config := corev1apply.Service(name, namespace).WithUID(svc.UID)
+	if annotations != nil {
+		config = config.WithAnnotations(annotations)
 	}
 
-	_, err = c.k8sClient.CoreV1().ConfigMaps(configMapNamespace).Update(
-		context.Background(),
-		existingConfigMap,
-		metav1.UpdateOptions{},
-	)
-	if err != nil {
-		return err
-	}
-	log.Printf("Updated ConfigMap %s/%s", configMapNamespace, configMapName)
-	return nil
+	_, err = c.k8sClient.CoreV1().Services(namespace).Apply(ctx, config, metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        true,
+	})
+	return err
 }
 
 func NewKubernetesClient(settings settings.Settings) (*KubernetesClient, error) {
@@ -274,7 +518,7 @@ func NewKubernetesClient(settings settings.Settings) (*KubernetesClient, error)
 	// Try in-cluster config first
 	config, err = rest.InClusterConfig()
 	if err != nil {
-		log.Print("failed to get in-cluster config, trying kubeconfig")
+		slog.Debug("Failed to get in-cluster config, trying kubeconfig", logging.KeyError, err)
 
 		// Fallback to kubeconfig
 		kubeconfig := os.Getenv("KUBECONFIG")
@@ -293,14 +537,76 @@ func NewKubernetesClient(settings settings.Settings) (*KubernetesClient, error)
 		}
 	}
 
+	return newKubernetesClient(config, settings)
+}
+
+// NewKubernetesClientForCluster builds a client for a cluster definition. The
+// local client reads kubeconfig Secrets from the cluster the syncer runs in.
+func NewKubernetesClientForCluster(ctx context.Context, settings settings.Settings, cluster settings.Cluster, local *KubernetesClient) (*KubernetesClient, error) {
+	var clientConfig clientcmd.ClientConfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cluster.Context}
+
+	if cluster.KubeconfigSecret != nil {
+		secret, err := local.k8sClient.CoreV1().Secrets(cluster.KubeconfigSecret.Namespace).Get(
+			ctx,
+			cluster.KubeconfigSecret.Name,
+			metav1.GetOptions{},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		key := cluster.KubeconfigSecret.Key
+		if key == "" {
+			key = "kubeconfig"
+		}
+		data, ok := secret.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("secret %s/%s has no key %s", secret.Namespace, secret.Name, key)
+		}
+
+		kubeconfig, err := clientcmd.Load(data)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig = clientcmd.NewNonInteractiveClientConfig(*kubeconfig, cluster.Context, overrides, nil)
+	} else {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		loadingRules.ExplicitPath = cluster.Kubeconfig
+		clientConfig = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+	}
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return newKubernetesClient(config, settings)
+}
+
+func newKubernetesClient(config *rest.Config, settings settings.Settings) (*KubernetesClient, error) {
+	config.Timeout = settings.APITimeout
+
 	k8sClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	conf := KubernetesClient{
-		k8sClient: k8sClient,
-		Settings:  settings,
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKubernetesClientFor(k8sClient, dynamicClient, settings), nil
+}
+
+// NewKubernetesClientFor wires the event recording of a client around its
+// clientsets, given as fakes in tests
+func NewKubernetesClientFor(k8sClient kubernetes.Interface, dynamicClient dynamic.Interface, settings settings.Settings) *KubernetesClient {
+	return &KubernetesClient{
+		k8sClient:     k8sClient,
+		dynamicClient: dynamicClient,
+		events:        newEventWriter(&typedcorev1.EventSinkImpl{Interface: k8sClient.CoreV1().Events(metav1.NamespaceAll)}),
+		Settings:      settings,
 	}
-	return &conf, nil
 }
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordServiceEvent(t *testing.T) {
+	events := &eventWriter{queue: make(chan *v1.Event, 10)}
+	client := &KubernetesClient{events: events}
+
+	client.RecordServiceEvent("default", "nginx", "uid-1", v1.EventTypeNormal, EventReasonPrefixCreated, "Created Netbox prefix %s (id %d)", "10.0.0.1/32", 7)
+	client.RecordServiceEvent("default", "nginx", "", v1.EventTypeWarning, EventReasonPrefixDeleteFailed, "Failed to delete Netbox prefix %s", "10.0.0.1/32")
+	client.RecordServiceEvent("default", "", "", v1.EventTypeWarning, EventReasonPrefixDeleteFailed, "Failed to delete Netbox prefix %s", "10.0.0.2/32")
+
+	expected := []string{
+		"Normal PrefixCreated Created Netbox prefix 10.0.0.1/32 (id 7)",
+		"Warning PrefixDeleteFailed Failed to delete Netbox prefix 10.0.0.1/32",
+	}
+	for _, event := range expected {
+		select {
+		case result := <-events.queue:
+			if recorded := fmt.Sprintf("%s %s %s", result.Type, result.Reason, result.Message); recorded != event {
+				t.Errorf("recorded event %q, expected %q", recorded, event)
+			}
+			if result.InvolvedObject.Kind != "Service" || result.InvolvedObject.Name != "nginx" || result.Namespace != "default" {
+				t.Errorf("event %q is not on the service default/nginx: %+v", event, result.InvolvedObject)
+			}
+		default:
+			t.Errorf("event %q was not recorded", event)
+		}
+	}
+	if len(events.queue) != 0 {
+		t.Errorf("%d events recorded without a service name, expected none", len(events.queue))
+	}
+}
+
+func TestShutdownDroppedEvents(t *testing.T) {
+	// nothing reads the queue, every event is dropped
+	client := &KubernetesClient{events: &eventWriter{queue: make(chan *v1.Event), stop: make(chan struct{})}}
+	for _, name := range []string{"nginx", "redis", "api"} {
+		client.RecordServiceEvent("default", name, "", v1.EventTypeNormal, EventReasonPrefixDeleted, "Deleted Netbox prefix of %s", name)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Shutdown waited for dropped events")
+	}
+}
+
+func TestShutdownFlushesEvents(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := NewKubernetesClientFor(clientset, nil, settings.Settings{})
+
+	for _, name := range []string{"nginx", "redis", "api"} {
+		client.RecordServiceEvent("default", name, "", v1.EventTypeNormal, EventReasonPrefixDeleted, "Deleted Netbox prefix of %s", name)
+	}
+	client.Shutdown()
+
+	events, err := clientset.CoreV1().Events("default").List(t.Context(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 3 {
+		t.Errorf("%d events written before Shutdown returned, expected 3", len(events.Items))
+	}
+}
+
+func TestGetNamespaces(t *testing.T) {
+	namespaces := []runtime.Object{
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Annotations: map[string]string{SyncAnnotation: "false"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "platform"}},
+	}
+
+	tests := []struct {
+		name     string
+		settings settings.Settings
+		expected map[string]bool
+		listed   bool
+	}{
+		{
+			name:     "Names",
+			settings: settings.Settings{KubernetesNamespaceFilter: []string{"team-a", "team-b", "missing"}},
+			expected: map[string]bool{"team-a": true, "team-b": false},
+		},
+		{
+			name:     "Pattern",
+			settings: settings.Settings{KubernetesNamespacePattern: []string{"team-*"}},
+			expected: map[string]bool{"team-a": true, "team-b": false},
+			listed:   true,
+		},
+		{
+			name:     "Regex",
+			settings: settings.Settings{KubernetesNamespaceRegexps: []*regexp.Regexp{regexp.MustCompile("^plat")}},
+			expected: map[string]bool{"platform": true, "team-b": false},
+			listed:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(namespaces...)
+			client := &KubernetesClient{k8sClient: clientset, Settings: tt.settings}
+
+			result, err := client.getNamespaces(t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("getNamespaces = %v, expected %v", result, tt.expected)
+			}
+
+			listed := false
+			for _, action := range clientset.Actions() {
+				if action.GetVerb() == "list" && action.GetResource().Resource == "namespaces" {
+					listed = true
+				}
+			}
+			if listed != tt.listed {
+				t.Errorf("namespaces listed = %v, expected %v", listed, tt.listed)
+			}
+		})
+	}
+}
+
+func TestGetKubernetesServicePrecedence(t *testing.T) {
+	namespaces := []runtime.Object{
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-off", Annotations: map[string]string{SyncAnnotation: "false"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ops", Annotations: map[string]string{SyncAnnotation: "true"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unlisted"}},
+	}
+
+	tests := []struct {
+		name        string
+		namespace   string
+		annotation  string
+		serviceType v1.ServiceType
+		expected    bool
+	}{
+		{"Selected namespace", "team-a", "", v1.ServiceTypeLoadBalancer, true},
+		{"Unlisted namespace", "unlisted", "", v1.ServiceTypeLoadBalancer, false},
+		{"Opt-in in an unlisted namespace", "unlisted", "true", v1.ServiceTypeLoadBalancer, true},
+		{"Opt-in overrides the type filter", "unlisted", "true", v1.ServiceTypeClusterIP, true},
+		{"Opt-out in a selected namespace", "team-a", "false", v1.ServiceTypeLoadBalancer, false},
+		{"Opted-out namespace", "team-off", "", v1.ServiceTypeLoadBalancer, false},
+		{"Service opt-in in an opted-out namespace", "team-off", "true", v1.ServiceTypeLoadBalancer, true},
+		{"Opted-in namespace", "ops", "", v1.ServiceTypeLoadBalancer, true},
+		{"Service opt-out in an opted-in namespace", "ops", "false", v1.ServiceTypeLoadBalancer, false},
+		{"Type filter in an opted-in namespace", "ops", "", v1.ServiceTypeClusterIP, false},
+	}
+
+	objects := namespaces
+	for i, tt := range tests {
+		service := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("svc-%d", i), Namespace: tt.namespace},
+			Spec:       v1.ServiceSpec{Type: tt.serviceType, ExternalIPs: []string{fmt.Sprintf("10.0.0.%d", i+1)}},
+		}
+		if tt.annotation != "" {
+			service.Annotations = map[string]string{SyncAnnotation: tt.annotation}
+		}
+		objects = append(objects, service)
+	}
+
+	client := &KubernetesClient{
+		k8sClient: fake.NewSimpleClientset(objects...),
+		Settings: settings.Settings{
+			KubernetesNamespacePattern: []string{"team-*"},
+			KubernetesTypeFilter:       []string{string(v1.ServiceTypeLoadBalancer)},
+		},
+	}
+	services, err := client.GetKubernetesService(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	synced := make(map[string]bool)
+	for _, service := range services {
+		synced[service.Name] = true
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if synced[fmt.Sprintf("svc-%d", i)] != tt.expected {
+				t.Errorf("service synced = %v, expected %v", !tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetKubernetesServiceNamedNamespaces(t *testing.T) {
+	service := func(namespace string, name string, annotation string) *v1.Service {
+		service := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer, ExternalIPs: []string{"10.0.0.1"}},
+		}
+		if annotation != "" {
+			service.Annotations = map[string]string{SyncAnnotation: annotation}
+		}
+		return service
+	}
+	clientset := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-off", Annotations: map[string]string{SyncAnnotation: "false"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unlisted"}},
+		service("team-a", "web", ""),
+		service("team-off", "db", ""),
+		service("team-off", "api", "true"),
+		service("unlisted", "cache", "true"),
+	)
+	client := &KubernetesClient{
+		k8sClient: clientset,
+		Settings:  settings.Settings{KubernetesNamespaceFilter: []string{"team-a", "team-off", "missing"}},
+	}
+
+	services, err := client.GetKubernetesService(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var synced []string
+	for _, service := range services {
+		synced = append(synced, service.Namespace+"/"+service.Name)
+	}
+	if expected := []string{"team-a/web", "team-off/api"}; !reflect.DeepEqual(synced, expected) {
+		t.Errorf("synced services %v, expected %v", synced, expected)
+	}
+
+	var listed []string
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "services" {
+			listed = append(listed, action.GetNamespace())
+		}
+	}
+	if expected := []string{"team-a", "team-off", "missing"}; !reflect.DeepEqual(listed, expected) {
+		t.Errorf("services listed in the namespaces %q, expected %q", listed, expected)
+	}
+}
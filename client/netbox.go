@@ -1,25 +1,79 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/netbox-community/go-netbox/v4"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/metrics"
 	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
 	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
 	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/utils"
 )
 
+// ErrDNSResolution is returned when the external hostname of a service cannot be resolved
+var ErrDNSResolution = errors.New("failed to resolve DNS")
+
+const (
+	ownershipHeader        = "Managed by kubernetes-service-netbox-syncer."
+	ownershipClusterKey    = "cluster"
+	ownershipNamespaceKey  = "namespace"
+	ownershipServiceKey    = "service"
+	ownershipExternalIPKey = "external-ip"
+	// removedAtKey is the comments line of a marked prefix recording
+	// since when, so the marks survive a lost state
+	removedAtKey = "removed-at"
+	// protectedKey and retainedKey are the comments lines of the protected
+	// and retained marks of a prefix, kept by the Netbox state backend
+	protectedKey = "protected"
+	retainedKey  = "retained"
+
+	listPageSize = 200
+)
+
 type NetboxClient struct {
 	netboxClient *netbox.APIClient
 	settings     settings.Settings
+	// ensuredTags holds the slugs of the tags known to exist in Netbox,
+	// guarded by tagsLock as prefixes are created and marked concurrently
+	ensuredTags map[string]bool
+	tagsLock    sync.Mutex
 }
 
 func (c *NetboxClient) Client() *netbox.APIClient {
 	return c.netboxClient
 }
 
-func (c *NetboxClient) CreatePrefix(service model.KubernetesService) ([]model.Prefix, error) {
+func (c *NetboxClient) CreatePrefix(ctx context.Context, service model.KubernetesService) ([]model.Prefix, error) {
+	requests, err := c.prefixRequests(ctx, service)
+	if err != nil {
+		return []model.Prefix{}, err
+	}
+
+	prefixes := []model.Prefix{}
+	for _, request := range requests {
+		prefix, _, err := c.netboxClient.IpamAPI.IpamPrefixesCreate(ctx).WritablePrefixRequest(request).Execute()
+		if err != nil {
+			return []model.Prefix{}, fmt.Errorf("failed to create prefix in Netbox: %v", apiError(err))
+		}
+
+		prefixes = append(prefixes, c.newPrefix(service, request.Prefix, prefix.Id, prefix.GetCreated(), prefix.GetLastUpdated()))
+	}
+
+	return prefixes, nil
+}
+
+// prefixRequests returns the Netbox prefixes to create for a service, one
+// for an external IP and one per address of an external hostname
+func (c *NetboxClient) prefixRequests(ctx context.Context, service model.KubernetesService) ([]netbox.WritablePrefixRequest, error) {
 	customFields := make(map[string]interface{})
 	for _, field := range c.settings.NetboxCustomField {
 		for k, v := range field {
@@ -27,83 +81,512 @@ func (c *NetboxClient) CreatePrefix(service model.KubernetesService) ([]model.Pr
 		}
 	}
 
-	prefixes := []model.Prefix{}
+	requests := []netbox.WritablePrefixRequest{}
 	markUtilized := true
 	isPool := false
 
-	if utils.CheckIP(service.ExternalIPs) {
-		description := fmt.Sprintf("%s-%s-%s-%s", service.ExternalIPs, service.Name, service.Namespace, c.settings.KubernetesCluster)
+	tags, comments, err := c.ownership(ctx, service)
+	if err != nil {
+		return nil, err
+	}
 
-		prefix, _, err := c.netboxClient.IpamAPI.IpamPrefixesCreate(context.Background()).WritablePrefixRequest(netbox.WritablePrefixRequest{
-			Prefix:      service.ExternalIPs + "/32",
+	request := func(ip string, description string) netbox.WritablePrefixRequest {
+		return netbox.WritablePrefixRequest{
+			Prefix:      ip + "/32",
 			Description: &description,
 
 			Status:       netbox.PATCHEDWRITABLEPREFIXREQUESTSTATUS_ACTIVE.Ptr(),
 			IsPool:       &isPool,
 			MarkUtilized: &markUtilized,
 			CustomFields: customFields,
-		}).Execute()
-
-		if err != nil {
-			return []model.Prefix{}, fmt.Errorf("failed to create prefix in Netbox: %v", err)
+			Tags:         tags,
+			Comments:     comments,
 		}
+	}
 
-		prefixes = append(prefixes, model.Prefix{
-			PrefixID:    prefix.Id,
-			Prefix:      service.ExternalIPs + "/32",
-			ExternalIPs: service.ExternalIPs,
-			ServiceName: service.Name,
-			Namespace:   service.Namespace,
-		})
+	if utils.CheckIP(service.ExternalIPs) {
+		description := fmt.Sprintf("%s-%s-%s-%s", service.ExternalIPs, service.Name, service.Namespace, c.settings.KubernetesCluster)
+		requests = append(requests, request(service.ExternalIPs, description))
 	}
 
 	if utils.CheckDNS(service.ExternalIPs) {
 		IPs, err := utils.GetIPFromDNS(service.ExternalIPs)
 		if err != nil {
-			return []model.Prefix{}, fmt.Errorf("failed to resolve DNS %s: %v", service.ExternalIPs, err)
+			return nil, fmt.Errorf("%w %s: %v", ErrDNSResolution, service.ExternalIPs, err)
 		}
 
 		for _, ip := range IPs {
 			description := fmt.Sprintf("%s-%s-%s-%s-%s", ip, service.ExternalIPs, service.Name, service.Namespace, c.settings.KubernetesCluster)
+			requests = append(requests, request(ip, description))
+		}
+	}
 
-			prefix, _, err := c.netboxClient.IpamAPI.IpamPrefixesCreate(context.Background()).WritablePrefixRequest(netbox.WritablePrefixRequest{
-				Prefix:      ip + "/32",
-				Description: &description,
+	return requests, nil
+}
 
-				Status:       netbox.PATCHEDWRITABLEPREFIXREQUESTSTATUS_ACTIVE.Ptr(),
-				IsPool:       &isPool,
-				MarkUtilized: &markUtilized,
-				CustomFields: customFields,
-			}).Execute()
+// newPrefix returns the state record of a prefix created for a service
+func (c *NetboxClient) newPrefix(service model.KubernetesService, prefix string, id int32, createdAt time.Time, updatedAt time.Time) model.Prefix {
+	return model.Prefix{
+		PrefixID:    id,
+		Prefix:      prefix,
+		ExternalIPs: service.ExternalIPs,
+		ServiceName: service.Name,
+		Namespace:   service.Namespace,
+		Cluster:     c.settings.KubernetesCluster,
+		ObjectType:  model.ObjectTypePrefix,
+		CreatedAt:   createdAt,
+		UpdatedAt:   updatedAt,
+		Protected:   service.Protected,
+	}
+}
 
-			if err != nil {
-				return []model.Prefix{}, fmt.Errorf("failed to create prefix in Netbox: %v", err)
+// ownership returns the ownership tag and comments of the prefixes of a
+// service, both are empty when NETBOX_OWNERSHIP_TAG is not set
+func (c *NetboxClient) ownership(ctx context.Context, service model.KubernetesService) ([]netbox.NestedTagRequest, *string, error) {
+	if c.settings.NetboxOwnershipTag == "" {
+		return nil, nil, nil
+	}
+
+	err := c.ensureTag(ctx, c.settings.NetboxOwnershipTag, "Objects managed by kubernetes-service-netbox-syncer")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to ensure ownership tag in Netbox: %v", err)
+	}
+
+	tags := []netbox.NestedTagRequest{{
+		Name: c.settings.NetboxOwnershipTag,
+		Slug: utils.Slugify(c.settings.NetboxOwnershipTag),
+	}}
+	comments := strings.Join([]string{
+		ownershipHeader,
+		ownershipClusterKey + ": " + c.settings.KubernetesCluster,
+		ownershipNamespaceKey + ": " + service.Namespace,
+		ownershipServiceKey + ": " + service.Name,
+		ownershipExternalIPKey + ": " + service.ExternalIPs,
+	}, "\n")
+
+	return tags, &comments, nil
+}
+
+// ensureTag creates a tag in Netbox if it does not exist
+func (c *NetboxClient) ensureTag(ctx context.Context, name string, description string) error {
+	c.tagsLock.Lock()
+	defer c.tagsLock.Unlock()
+
+	slug := utils.Slugify(name)
+	if c.ensuredTags[slug] {
+		return nil
+	}
+
+	tags, _, err := c.netboxClient.ExtrasAPI.ExtrasTagsList(ctx).Slug([]string{slug}).Execute()
+	if err != nil {
+		return apiError(err)
+	}
+
+	if tags.Count == 0 {
+		_, _, err = c.netboxClient.ExtrasAPI.ExtrasTagsCreate(ctx).TagRequest(netbox.TagRequest{
+			Name:        name,
+			Slug:        slug,
+			Description: &description,
+		}).Execute()
+		if err != nil {
+			return apiError(err)
+		}
+	}
+
+	c.ensuredTags[slug] = true
+	return nil
+}
+
+// ListOwnedPrefixes returns the prefixes of the cluster carrying the
+// ownership tag, rebuilt from their ownership comments
+func (c *NetboxClient) ListOwnedPrefixes(ctx context.Context) ([]model.Prefix, error) {
+	prefixes := []model.Prefix{}
+	slug := utils.Slugify(c.settings.NetboxOwnershipTag)
+
+	for offset := int32(0); ; {
+		list, _, err := c.netboxClient.IpamAPI.IpamPrefixesList(ctx).
+			Tag([]string{slug}).
+			Limit(listPageSize).
+			Offset(offset).
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prefixes in Netbox: %v", apiError(err))
+		}
+
+		for _, prefix := range list.Results {
+			owner := parseOwnership(prefix.GetComments())
+			if owner[ownershipClusterKey] != c.settings.KubernetesCluster {
+				continue
 			}
 
 			prefixes = append(prefixes, model.Prefix{
 				PrefixID:    prefix.Id,
-				Prefix:      ip + "/32",
-				ExternalIPs: service.ExternalIPs,
-				ServiceName: service.Name,
-				Namespace:   service.Namespace,
+				Prefix:      prefix.Prefix,
+				ExternalIPs: owner[ownershipExternalIPKey],
+				ServiceName: owner[ownershipServiceKey],
+				Namespace:   owner[ownershipNamespaceKey],
+				Cluster:     c.settings.KubernetesCluster,
+				ObjectType:  model.ObjectTypePrefix,
+				CreatedAt:   prefix.GetCreated(),
+				UpdatedAt:   prefix.GetLastUpdated(),
+				RemovedAt:   removedAt(owner),
+				Protected:   owner[protectedKey] == "true",
+				Retained:    owner[retainedKey] == "true",
 			})
 		}
+
+		offset += int32(len(list.Results))
+		if len(list.Results) == 0 || offset >= list.Count {
+			break
+		}
 	}
 
 	return prefixes, nil
 }
 
-func (c *NetboxClient) DeletePrefix(id int32) error {
-	_, err := c.netboxClient.IpamAPI.IpamPrefixesDestroy(context.Background(), id).Execute()
+// DiscoveredPrefix is a prefix found in Netbox that the syncer created for the
+// cluster, with the service of the Prefix filled in from its ownership comments
+type DiscoveredPrefix struct {
+	model.Prefix
+	Description string
+}
+
+// DiscoverPrefixes returns the prefixes carrying the ownership tag whose
+// description ends with the cluster name and whose ownership comments name the
+// cluster. Without an ownership tag the prefixes of the syncer cannot be told
+// apart from those of another cluster or made by hand, so it fails.
+func (c *NetboxClient) DiscoverPrefixes(ctx context.Context) ([]DiscoveredPrefix, error) {
+	if c.settings.NetboxOwnershipTag == "" {
+		return nil, fmt.Errorf("discovering prefixes requires NETBOX_OWNERSHIP_TAG")
+	}
+
+	prefixes := []DiscoveredPrefix{}
+	suffix := "-" + c.settings.KubernetesCluster
+	slug := utils.Slugify(c.settings.NetboxOwnershipTag)
+
+	for offset := int32(0); ; {
+		list, _, err := c.netboxClient.IpamAPI.IpamPrefixesList(ctx).
+			Tag([]string{slug}).
+			DescriptionIew([]string{suffix}).
+			Limit(listPageSize).
+			Offset(offset).
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prefixes in Netbox: %v", apiError(err))
+		}
+
+		for _, prefix := range list.Results {
+			// the filter is case insensitive, the fingerprint is not
+			ip := strings.TrimSuffix(prefix.Prefix, "/32")
+			if !strings.HasPrefix(prefix.GetDescription(), ip+"-") || !strings.HasSuffix(prefix.GetDescription(), suffix) {
+				continue
+			}
+
+			// skip the prefixes of another cluster, including one whose name
+			// ends with this one, e.g. prod-a when syncing a
+			owner := parseOwnership(prefix.GetComments())
+			if !strings.HasPrefix(prefix.GetComments(), ownershipHeader) || owner[ownershipClusterKey] != c.settings.KubernetesCluster {
+				continue
+			}
+
+			prefixes = append(prefixes, DiscoveredPrefix{
+				Prefix: model.Prefix{
+					PrefixID:    prefix.Id,
+					Prefix:      prefix.Prefix,
+					ExternalIPs: owner[ownershipExternalIPKey],
+					ServiceName: owner[ownershipServiceKey],
+					Namespace:   owner[ownershipNamespaceKey],
+					Cluster:     c.settings.KubernetesCluster,
+					ObjectType:  model.ObjectTypePrefix,
+					CreatedAt:   prefix.GetCreated(),
+					UpdatedAt:   prefix.GetLastUpdated(),
+					RemovedAt:   removedAt(owner),
+				},
+				Description: prefix.GetDescription(),
+			})
+		}
+
+		offset += int32(len(list.Results))
+		if len(list.Results) == 0 || offset >= list.Count {
+			break
+		}
+	}
+
+	return prefixes, nil
+}
+
+// parseOwnership reads the key: value lines of ownership comments
+func parseOwnership(comments string) map[string]string {
+	owner := make(map[string]string)
+	for _, line := range strings.Split(comments, "\n") {
+		key, value, found := strings.Cut(line, ": ")
+		if found {
+			owner[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return owner
+}
+
+// LookupPrefix returns the prefix of a Netbox prefix ID, found is false when
+// the prefix does not exist
+func (c *NetboxClient) LookupPrefix(ctx context.Context, id int32) (string, bool, error) {
+	prefix, response, err := c.netboxClient.IpamAPI.IpamPrefixesRetrieve(ctx, id).Execute()
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to get prefix %d from Netbox: %v", id, apiError(err))
+	}
+
+	return prefix.Prefix, true, nil
+}
+
+// DeletePrefix deletes a prefix, one that does not exist, like one deleted
+// by a previous attempt or by hand, is already gone
+func (c *NetboxClient) DeletePrefix(ctx context.Context, id int32) error {
+	response, err := c.netboxClient.IpamAPI.IpamPrefixesDestroy(ctx, id).Execute()
+	if err != nil && response != nil && response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return apiError(err)
+}
+
+// PrefixProtection returns the protection marker of a prefix, NETBOX_PROTECTION_TAG
+// or a true NETBOX_PROTECTION_CUSTOM_FIELD, empty when it has none or does not exist
+func (c *NetboxClient) PrefixProtection(ctx context.Context, id int32) (string, error) {
+	if c.settings.NetboxProtectionTag == "" && c.settings.NetboxProtectionCustomField == "" {
+		return "", nil
+	}
+
+	prefix, response, err := c.netboxClient.IpamAPI.IpamPrefixesRetrieve(ctx, id).Execute()
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get prefix %d from Netbox: %v", id, apiError(err))
+	}
+
+	if c.settings.NetboxProtectionTag != "" {
+		slug := utils.Slugify(c.settings.NetboxProtectionTag)
+		for _, tag := range prefix.Tags {
+			if tag.Slug == slug {
+				return "tag " + c.settings.NetboxProtectionTag, nil
+			}
+		}
+	}
+
+	if field := c.settings.NetboxProtectionCustomField; field != "" {
+		switch value := prefix.CustomFields[field].(type) {
+		case bool:
+			if value {
+				return "custom field " + field, nil
+			}
+		case string:
+			if protect, _ := strconv.ParseBool(value); protect {
+				return "custom field " + field, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// UpdateOwnedPrefix records the removal time and the protected and retained
+// marks of an owned prefix in its comments, where ListOwnedPrefixes reads
+// them back. Its status, tags and custom fields are left untouched.
+func (c *NetboxClient) UpdateOwnedPrefix(ctx context.Context, owned model.Prefix) error {
+	prefix, _, err := c.netboxClient.IpamAPI.IpamPrefixesRetrieve(ctx, owned.PrefixID).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to get prefix %d from Netbox: %v", owned.PrefixID, apiError(err))
+	}
+
+	comments := removedComments(prefix.GetComments(), owned.RemovedAt)
+	comments = commentLine(comments, protectedKey, markValue(owned.Protected))
+	comments = commentLine(comments, retainedKey, markValue(owned.Retained))
+	request := netbox.PatchedWritablePrefixRequest{Comments: &comments}
+
+	_, _, err = c.netboxClient.IpamAPI.IpamPrefixesPartialUpdate(ctx, owned.PrefixID).PatchedWritablePrefixRequest(request).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to update prefix %d in Netbox: %v", owned.PrefixID, apiError(err))
+	}
+	return nil
+}
+
+// ReleasePrefix removes the ownership tag from a prefix so that it is no
+// longer listed as owned, a prefix that does not exist is already released
+func (c *NetboxClient) ReleasePrefix(ctx context.Context, id int32) error {
+	prefix, response, err := c.netboxClient.IpamAPI.IpamPrefixesRetrieve(ctx, id).Execute()
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to get prefix %d from Netbox: %v", id, apiError(err))
+	}
+
+	slug := utils.Slugify(c.settings.NetboxOwnershipTag)
+	tags := []netbox.NestedTagRequest{}
+	for _, tag := range prefix.Tags {
+		if tag.Slug != slug {
+			tags = append(tags, netbox.NestedTagRequest{Name: tag.Name, Slug: tag.Slug})
+		}
+	}
+	request := netbox.PatchedWritablePrefixRequest{Tags: tags}
+
+	_, _, err = c.netboxClient.IpamAPI.IpamPrefixesPartialUpdate(ctx, id).PatchedWritablePrefixRequest(request).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to update prefix %d in Netbox: %v", id, apiError(err))
+	}
+	return nil
+}
+
+// markValue returns the comments value of a mark, empty when it is unset
+func markValue(set bool) string {
+	if set {
+		return "true"
+	}
+	return ""
+}
+
+// DeprecatePrefix sets the status of the prefix of a removed service to
+// deprecated and records when the service was removed
+func (c *NetboxClient) DeprecatePrefix(ctx context.Context, id int32, at time.Time) error {
+	status := netbox.PATCHEDWRITABLEPREFIXREQUESTSTATUS_DEPRECATED
+	return c.markPrefix(ctx, id, &status, false, at)
+}
+
+// TagPrefixRemoved adds NETBOX_REMOVED_TAG to the prefix of a removed service
+// and records when the service was removed, its status is left untouched
+func (c *NetboxClient) TagPrefixRemoved(ctx context.Context, id int32, at time.Time) error {
+	return c.markPrefix(ctx, id, nil, true, at)
+}
+
+// RestorePrefix removes the marks of a removed service from its prefix once
+// the service is back, a deprecated prefix is set back to active
+func (c *NetboxClient) RestorePrefix(ctx context.Context, id int32) error {
+	return c.markPrefix(ctx, id, nil, false, time.Time{})
+}
+
+// markPrefix records the removal time at in the comments of a prefix and in
+// NETBOX_REMOVED_CUSTOM_FIELD, sets its status when given and adds or removes
+// NETBOX_REMOVED_TAG. A zero at removes the marks. The other comments, tags
+// and custom fields are kept.
+func (c *NetboxClient) markPrefix(ctx context.Context, id int32, status *netbox.PatchedWritablePrefixRequestStatus, tagged bool, at time.Time) error {
+	prefix, _, err := c.netboxClient.IpamAPI.IpamPrefixesRetrieve(ctx, id).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to get prefix %d from Netbox: %v", id, apiError(err))
+	}
+
+	comments := removedComments(prefix.GetComments(), at)
+	request := netbox.PatchedWritablePrefixRequest{
+		Status:   status,
+		Comments: &comments,
+	}
+	if at.IsZero() && prefix.Status != nil && prefix.Status.GetValue() == netbox.PREFIXSTATUSVALUE_DEPRECATED {
+		request.Status = netbox.PATCHEDWRITABLEPREFIXREQUESTSTATUS_ACTIVE.Ptr()
+	}
+
+	if c.settings.NetboxRemovedCustomField != "" {
+		var value any
+		if !at.IsZero() {
+			value = at.UTC().Format(time.RFC3339)
+		}
+		request.CustomFields = map[string]any{c.settings.NetboxRemovedCustomField: value}
+	}
+
+	if c.settings.NetboxRemovedTag != "" {
+		slug := utils.Slugify(c.settings.NetboxRemovedTag)
+		tags := []netbox.NestedTagRequest{}
+		for _, tag := range prefix.Tags {
+			if tag.Slug != slug {
+				tags = append(tags, netbox.NestedTagRequest{Name: tag.Name, Slug: tag.Slug})
+			}
+		}
+		if tagged {
+			err := c.ensureTag(ctx, c.settings.NetboxRemovedTag, "Objects of Kubernetes services removed from the cluster")
+			if err != nil {
+				return fmt.Errorf("failed to ensure removed tag in Netbox: %v", err)
+			}
+			tags = append(tags, netbox.NestedTagRequest{Name: c.settings.NetboxRemovedTag, Slug: slug})
+		}
+		request.Tags = tags
+	}
+
+	_, _, err = c.netboxClient.IpamAPI.IpamPrefixesPartialUpdate(ctx, id).PatchedWritablePrefixRequest(request).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to update prefix %d in Netbox: %v", id, apiError(err))
+	}
+	return nil
+}
+
+// removedComments replaces the removed-at line of comments with at, or
+// removes it when at is zero
+func removedComments(comments string, at time.Time) string {
+	value := ""
+	if !at.IsZero() {
+		value = at.UTC().Format(time.RFC3339)
+	}
+	return commentLine(comments, removedAtKey, value)
+}
+
+// commentLine replaces the key line of comments with value, or removes it
+// when value is empty
+func commentLine(comments string, key string, value string) string {
+	var lines []string
+	for _, line := range strings.Split(comments, "\n") {
+		lineKey, _, found := strings.Cut(line, ": ")
+		if found && strings.TrimSpace(lineKey) == key {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if value != "" {
+		lines = append(lines, key+": "+value)
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// removedAt returns the time of the removed-at line of parsed comments,
+// zero when there is none
+func removedAt(owner map[string]string) time.Time {
+	at, err := time.Parse(time.RFC3339, owner[removedAtKey])
+	if err != nil {
+		return time.Time{}
+	}
+	return at
+}
+
+// apiError adds the body of a Netbox error response to the error, it holds
+// the reason of validation errors
+func apiError(err error) error {
+	var openAPIError *netbox.GenericOpenAPIError
+	if errors.As(err, &openAPIError) && len(openAPIError.Body()) > 0 {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(openAPIError.Body()))
+	}
 	return err
 }
 
+// PrefixURL returns the Netbox web UI URL of a prefix
+func (c *NetboxClient) PrefixURL(id int32) string {
+	baseURL := strings.TrimSuffix(strings.TrimSuffix(c.settings.NetboxURL, "/"), "/api")
+	return fmt.Sprintf("%s/ipam/prefixes/%d/", baseURL, id)
+}
+
 func NewNetboxClient(settings settings.Settings) (*NetboxClient, error) {
 	client := netbox.NewAPIClientFor(settings.NetboxURL, settings.NetboxAPIToken)
+	client.GetConfig().AddDefaultHeader(logging.RequestIDHeader, logging.RunID())
+	client.GetConfig().HTTPClient = &http.Client{
+		Transport: &retryTransport{
+			next:     metrics.InstrumentRoundTripper(http.DefaultTransport),
+			attempts: settings.NetboxRetryAttempts,
+			backoff:  settings.NetboxRetryBackoff,
+			timeout:  settings.APITimeout,
+		},
+	}
 
 	c := NetboxClient{
 		netboxClient: client,
 		settings:     settings,
+		ensuredTags:  make(map[string]bool),
 	}
 	return &c, nil
 }
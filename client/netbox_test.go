@@ -0,0 +1,212 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeprecatedComments(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	owned := "Managed by kubernetes-service-netbox-syncer.\ncluster: prod-a"
+
+	tests := []struct {
+		name     string
+		comments string
+		at       time.Time
+		expected string
+	}{
+		{"Deprecate without comments", "", at, "removed-at: 2026-01-01T12:00:00Z"},
+		{"Deprecate owned prefix", owned, at, owned + "\nremoved-at: 2026-01-01T12:00:00Z"},
+		{"Deprecate again", owned + "\nremoved-at: 2025-01-01T00:00:00Z", at, owned + "\nremoved-at: 2026-01-01T12:00:00Z"},
+		{"Restore", owned + "\nremoved-at: 2026-01-01T12:00:00Z", time.Time{}, owned},
+		{"Restore without comments", "removed-at: 2026-01-01T12:00:00Z", time.Time{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := removedComments(tt.comments, tt.at)
+			if result != tt.expected {
+				t.Errorf("removedComments(%q) = %q, expected %q", tt.comments, result, tt.expected)
+			}
+			if !removedAt(parseOwnership(result)).Equal(tt.at) {
+				t.Errorf("removedAt(%q) = %s, expected %s", result, removedAt(parseOwnership(result)), tt.at)
+			}
+		})
+	}
+}
+
+func TestMarkPrefix(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ownerTag := map[string]any{"id": 1, "url": "http://netbox/api/extras/tags/1/", "display": "owner", "name": "owner", "slug": "owner"}
+	removedTag := map[string]any{"id": 2, "url": "http://netbox/api/extras/tags/2/", "display": "k8s-removed", "name": "k8s-removed", "slug": "k8s-removed"}
+
+	tests := []struct {
+		name     string
+		status   string
+		comments string
+		tags     []map[string]any
+		mark     func(c *NetboxClient) error
+		expected map[string]any
+	}{
+		{
+			name:     "Deprecate",
+			status:   "active",
+			comments: "cluster: prod-a",
+			tags:     []map[string]any{ownerTag},
+			mark:     func(c *NetboxClient) error { return c.DeprecatePrefix(t.Context(), 7, at) },
+			expected: map[string]any{
+				"status":        "deprecated",
+				"comments":      "cluster: prod-a\nremoved-at: 2026-01-01T12:00:00Z",
+				"tags":          []any{map[string]any{"name": "owner", "slug": "owner"}},
+				"custom_fields": map[string]any{"removed_at": "2026-01-01T12:00:00Z"},
+			},
+		},
+		{
+			name:     "Tag",
+			status:   "active",
+			comments: "cluster: prod-a",
+			tags:     []map[string]any{ownerTag},
+			mark:     func(c *NetboxClient) error { return c.TagPrefixRemoved(t.Context(), 7, at) },
+			expected: map[string]any{
+				"comments":      "cluster: prod-a\nremoved-at: 2026-01-01T12:00:00Z",
+				"tags":          []any{map[string]any{"name": "owner", "slug": "owner"}, map[string]any{"name": "k8s-removed", "slug": "k8s-removed"}},
+				"custom_fields": map[string]any{"removed_at": "2026-01-01T12:00:00Z"},
+			},
+		},
+		{
+			name:     "Restore",
+			status:   "deprecated",
+			comments: "cluster: prod-a\nremoved-at: 2026-01-01T12:00:00Z",
+			tags:     []map[string]any{ownerTag, removedTag},
+			mark:     func(c *NetboxClient) error { return c.RestorePrefix(t.Context(), 7) },
+			expected: map[string]any{
+				"status":        "active",
+				"comments":      "cluster: prod-a",
+				"tags":          []any{map[string]any{"name": "owner", "slug": "owner"}},
+				"custom_fields": map[string]any{"removed_at": nil},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch map[string]any
+			prefix := map[string]any{
+				"id":       7,
+				"url":      "http://netbox/api/ipam/prefixes/7/",
+				"display":  "10.0.0.1/32",
+				"family":   map[string]any{"value": 4, "label": "IPv4"},
+				"prefix":   "10.0.0.1/32",
+				"status":   map[string]any{"value": tt.status, "label": strings.ToUpper(tt.status[:1]) + tt.status[1:]},
+				"comments": tt.comments,
+				"tags":     tt.tags,
+				"children": 0,
+				"_depth":   0,
+			}
+			client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != prefixesPath+"7/" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				if r.Method == http.MethodPatch {
+					json.NewDecoder(r.Body).Decode(&patch)
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(prefix)
+			})
+			client.settings.NetboxRemovedTag = "k8s-removed"
+			client.settings.NetboxRemovedCustomField = "removed_at"
+			client.ensuredTags["k8s-removed"] = true
+
+			if err := tt.mark(client); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(patch, tt.expected) {
+				t.Errorf("patch = %v, expected %v", patch, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrefixProtection(t *testing.T) {
+	tests := []struct {
+		name         string
+		tags         []map[string]any
+		customFields map[string]any
+		notFound     bool
+		expected     string
+	}{
+		{"Unprotected", nil, nil, false, ""},
+		{"Protection tag", []map[string]any{{"id": 3, "url": "http://netbox/api/extras/tags/3/", "display": "k8s-protected", "name": "k8s-protected", "slug": "k8s-protected"}}, nil, false, "tag k8s-protected"},
+		{"Protection custom field", nil, map[string]any{"protected": true}, false, "custom field protected"},
+		{"Protection custom field unset", nil, map[string]any{"protected": false}, false, ""},
+		{"Missing prefix", nil, nil, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.notFound {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]any{
+					"id":            7,
+					"url":           "http://netbox/api/ipam/prefixes/7/",
+					"display":       "10.0.0.1/32",
+					"family":        map[string]any{"value": 4, "label": "IPv4"},
+					"prefix":        "10.0.0.1/32",
+					"tags":          tt.tags,
+					"custom_fields": tt.customFields,
+					"children":      0,
+					"_depth":        0,
+				})
+			})
+			client.settings.NetboxProtectionTag = "k8s-protected"
+			client.settings.NetboxProtectionCustomField = "protected"
+
+			reason, err := client.PrefixProtection(t.Context(), 7)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if reason != tt.expected {
+				t.Errorf("PrefixProtection = %q, expected %q", reason, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		expectErr bool
+	}{
+		{"Deleted", http.StatusNoContent, false},
+		{"Missing prefix", http.StatusNotFound, false},
+		{"Dependent objects", http.StatusConflict, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestNetboxClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete || r.URL.Path != "/api/ipam/prefixes/7/" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+			})
+
+			err := client.DeletePrefix(t.Context(), 7)
+			if tt.expectErr != (err != nil) {
+				t.Errorf("DeletePrefix returned %v, expected error %v", err, tt.expectErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ListSyncPolicies returns every NetboxSyncPolicy of the cluster
+func (c *KubernetesClient) ListSyncPolicies(ctx context.Context) ([]v1alpha1.NetboxSyncPolicy, error) {
+	var policies []v1alpha1.NetboxSyncPolicy
+
+	list, err := c.dynamicClient.Resource(v1alpha1.NetboxSyncPolicyResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range list.Items {
+		var policy v1alpha1.NetboxSyncPolicy
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &policy)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// UpdateSyncPolicyStatus writes the status subresource of a NetboxSyncPolicy
+func (c *KubernetesClient) UpdateSyncPolicyStatus(ctx context.Context, policy v1alpha1.NetboxSyncPolicy) error {
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&policy)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.dynamicClient.Resource(v1alpha1.NetboxSyncPolicyResource).UpdateStatus(
+		ctx,
+		&unstructured.Unstructured{Object: object},
+		metav1.UpdateOptions{FieldManager: FieldManager},
+	)
+	return err
+}
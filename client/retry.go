@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/metrics"
+)
+
+// maxRetryBackoff caps the exponential backoff between two attempts
+const maxRetryBackoff = 30 * time.Second
+
+// retryTransport retries the Netbox requests that failed with a transient
+// error, waiting with an exponential backoff and jitter or as long as the
+// Retry-After header asks. Each attempt is bounded by timeout.
+type retryTransport struct {
+	next     http.RoundTripper
+	attempts int
+	backoff  time.Duration
+	timeout  time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		response, err := t.roundTrip(req)
+		if attempt >= t.attempts || !retryable(req, response, err) {
+			return response, err
+		}
+
+		delay := t.delay(attempt, response)
+		code := "error"
+		if response != nil {
+			code = strconv.Itoa(response.StatusCode)
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+		metrics.NetboxRetries.WithLabelValues(code).Inc()
+		slog.Warn("Retrying Netbox request", "method", req.Method, "path", req.URL.Path, "attempt", attempt, "code", code, "delay", delay, logging.KeyError, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return nil, errors.New("cannot retry a request whose body cannot be rewound")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// roundTrip makes one attempt, the attempt timeout is released once the
+// response body is closed
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+
+	response, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	response.Body = &cancelOnClose{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+// delay returns how long to wait before the next attempt, Retry-After when
+// the response has one
+func (t *retryTransport) delay(attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if delay, ok := retryAfter(response.Header.Get("Retry-After")); ok {
+			return delay
+		}
+	}
+
+	backoff := t.backoff << (attempt - 1)
+	if backoff <= 0 || backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	// equal jitter, between half and the full backoff
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// retryable tells whether an attempt failed with a transient error. Network
+// errors, rate limiting and unavailable upstreams are retried, other errors
+// such as validation errors are permanent. A non-idempotent request that may
+// have reached Netbox, a POST creating a prefix, is only retried when Netbox
+// turned it away, so that a retry never creates the same object twice.
+func retryable(req *http.Request, response *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	idempotent := idempotentMethods[req.Method]
+	if err != nil {
+		return idempotent || notSent(err)
+	}
+
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// idempotentMethods are the methods whose requests can be repeated without
+// changing the outcome
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+}
+
+// notSent tells whether a request failed before it was sent, because the
+// connection to Netbox could not be established
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryAfter parses a Retry-After header, either seconds or an HTTP date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+
+	return 0, false
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
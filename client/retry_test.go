@@ -0,0 +1,221 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		responses        []int
+		attempts         int
+		expectedCode     int
+		expectedAttempts int32
+	}{
+		{
+			name:             "Success",
+			method:           http.MethodPost,
+			responses:        []int{http.StatusCreated},
+			attempts:         3,
+			expectedCode:     http.StatusCreated,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "Transient errors",
+			method:           http.MethodPut,
+			responses:        []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK},
+			attempts:         3,
+			expectedCode:     http.StatusOK,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "Create turned away",
+			method:           http.MethodPost,
+			responses:        []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusCreated},
+			attempts:         3,
+			expectedCode:     http.StatusCreated,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "Create behind a failing gateway",
+			method:           http.MethodPost,
+			responses:        []int{http.StatusBadGateway, http.StatusCreated},
+			attempts:         3,
+			expectedCode:     http.StatusBadGateway,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "Attempts exhausted",
+			method:           http.MethodPost,
+			responses:        []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			attempts:         2,
+			expectedCode:     http.StatusServiceUnavailable,
+			expectedAttempts: 2,
+		},
+		{
+			name:             "Validation error",
+			method:           http.MethodPost,
+			responses:        []int{http.StatusBadRequest, http.StatusCreated},
+			attempts:         3,
+			expectedCode:     http.StatusBadRequest,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != `{"prefix":"10.0.0.1/32"}` {
+					t.Errorf("attempt %d got body %q", attempts.Load()+1, body)
+				}
+
+				code := tt.responses[attempts.Add(1)-1]
+				if code == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "0")
+				}
+				w.WriteHeader(code)
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: &retryTransport{
+				next:     http.DefaultTransport,
+				attempts: tt.attempts,
+				backoff:  time.Millisecond,
+				timeout:  time.Second,
+			}}
+
+			request, err := http.NewRequest(tt.method, server.URL, strings.NewReader(`{"prefix":"10.0.0.1/32"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := client.Do(request)
+			if err != nil {
+				t.Fatalf("%s unexpected error: %v", tt.method, err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != tt.expectedCode {
+				t.Errorf("StatusCode = %d, expected %d", response.StatusCode, tt.expectedCode)
+			}
+			if attempts.Load() != tt.expectedAttempts {
+				t.Errorf("attempts = %d, expected %d", attempts.Load(), tt.expectedAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryTransportTimeout(t *testing.T) {
+	tests := []struct {
+		method           string
+		expectedAttempts int32
+	}{
+		{http.MethodPost, 1},
+		{http.MethodPatch, 2},
+		{http.MethodDelete, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			var attempts atomic.Int32
+			release := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				<-release
+			}))
+			defer server.Close()
+			defer close(release)
+
+			client := &http.Client{Transport: &retryTransport{
+				next:     http.DefaultTransport,
+				attempts: 2,
+				backoff:  time.Millisecond,
+				timeout:  50 * time.Millisecond,
+			}}
+
+			request, err := http.NewRequest(tt.method, server.URL, strings.NewReader(`{"prefix":"10.0.0.1/32"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response, err := client.Do(request); err == nil {
+				response.Body.Close()
+				t.Fatalf("%s expected a timeout", tt.method)
+			}
+
+			if attempts.Load() != tt.expectedAttempts {
+				t.Errorf("attempts = %d, expected %d", attempts.Load(), tt.expectedAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryTransportRefused(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	var attempts atomic.Int32
+	client := &http.Client{Transport: &retryTransport{
+		next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempts.Add(1)
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+		attempts: 3,
+		backoff:  time.Millisecond,
+		timeout:  time.Second,
+	}}
+
+	response, err := client.Post(server.URL, "application/json", strings.NewReader(`{"prefix":"10.0.0.1/32"}`))
+	if err == nil {
+		response.Body.Close()
+		t.Fatal("Post expected a connection error")
+	}
+	if attempts.Load() != 3 {
+		t.Errorf("attempts = %d, expected 3 as the create never reached Netbox", attempts.Load())
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, true},
+	}
+
+	for _, tt := range tests {
+		delay, ok := retryAfter(tt.value)
+		if delay != tt.expected || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %s, %v, expected %s, %v", tt.value, delay, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	transport := &retryTransport{backoff: time.Second}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		backoff := min(time.Second<<(attempt-1), maxRetryBackoff)
+		delay := transport.delay(attempt, nil)
+		if delay < backoff/2 || delay > backoff {
+			t.Errorf("delay(%d) = %s, expected between %s and %s", attempt, delay, backoff/2, backoff)
+		}
+	}
+}
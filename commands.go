@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/audit"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/metrics"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/report"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/state"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/syncer"
+)
+
+// command is a subcommand of the binary. dryRun and file tell whether the
+// command accepts --dry-run and --file, file being the usage of the flag,
+// and query whether it accepts the journal query flags.
+type command struct {
+	description string
+	dryRun      bool
+	file        string
+	query       bool
+	run         func(env *environment) error
+}
+
+var commands = map[string]command{
+	"sync": {
+		description: "Create the prefixes of new services and delete the prefixes of removed ones. This is the default command.",
+		run:         runSync,
+	},
+	"plan": {
+		description: "Show the prefixes a sync would create and delete, without changing anything.",
+		run:         runPlan,
+	},
+	"list": {
+		description: "Show the services and the Netbox prefixes recorded in the state.",
+		run:         runList,
+	},
+	"verify": {
+		description: "Check the services, the state and Netbox for drift, failing when any is found.",
+		run:         runVerify,
+	},
+	"gc": {
+		description: "Remove the prefixes the syncer created in Netbox that are neither in the state nor match a live service, following the deletion policy.",
+		dryRun:      true,
+		run:         runGC,
+	},
+	"recover": {
+		description: "Rebuild the state from the prefixes the syncer created in Netbox.",
+		dryRun:      true,
+		run:         runRecover,
+	},
+	"export": {
+		description: "Write the state of one target to a file, as a backup.",
+		file:        "file to write the state to",
+		run:         runExport,
+	},
+	"import": {
+		description: "Replace the state of one target with a file written by export.",
+		file:        "file to read the state from",
+		run:         runImport,
+	},
+	"audit": {
+		description: "Show the changes made to Netbox recorded in the audit journal, optionally of one service or IP.",
+		query:       true,
+		run:         runAudit,
+	},
+}
+
+// usage prints the available commands
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: kubernetes-service-netbox-syncer [command] [flags]\n\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun a command with -h for its flags. Every setting has a flag named after its environment variable, e.g. --netbox-url for NETBOX_URL.\n")
+}
+
+func runSync(env *environment) error {
+	runReport := report.New(logging.RunID(), "sync")
+
+	var err error
+	if env.setting.KubernetesSyncPolicies {
+		err = syncPolicies(env, runReport)
+	} else {
+		err = syncTargets(env, runReport)
+	}
+	runReport.Finish(err)
+
+	// push even after a failure, the failure counters are what alerts need
+	if env.setting.MetricsPushgatewayURL != "" {
+		pushErr := metrics.Push(env.setting.MetricsPushgatewayURL, env.setting.MetricsPushgatewayJob, env.setting.KubernetesCluster)
+		if pushErr != nil {
+			slog.Error("Failed to push metrics", "url", env.setting.MetricsPushgatewayURL, logging.KeyError, pushErr)
+		} else {
+			slog.Info("Pushed metrics", "url", env.setting.MetricsPushgatewayURL)
+		}
+	}
+
+	if writeErr := writeReport(env, runReport); writeErr != nil {
+		slog.Error("Failed to write run report", "output", env.setting.ReportOutput, logging.KeyError, writeErr)
+	}
+
+	switch runReport.Status {
+	case report.StatusFailed:
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("all %d targets failed", len(runReport.Targets))
+	case report.StatusPartial:
+		return fmt.Errorf("%w, see the run report", errPartial)
+	}
+	return nil
+}
+
+// syncTargets syncs every target, recording the outcome of each in the
+// report. A failing target does not stop the others.
+func syncTargets(env *environment, runReport *report.Report) error {
+	targets, err := env.targets()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		slog.Info("Syncing cluster", logging.KeyCluster, t.name)
+
+		var result syncer.Result
+		s, shutdown, err := t.build()
+		if err == nil {
+			result, err = s.Run(env.ctx)
+			shutdown()
+		}
+		if err != nil {
+			slog.Error("Failed to sync cluster", logging.KeyCluster, t.name, logging.KeyError, err)
+		}
+		runReport.AddTarget(targetReport(t.name, result), err)
+	}
+
+	return nil
+}
+
+// targetReport returns the report of the sync of one target
+func targetReport(name string, result syncer.Result) report.Target {
+	return report.Target{
+		Name:      name,
+		Prefixes:  result.Prefixes,
+		Created:   result.Created,
+		Deleted:   result.Deleted,
+		Marked:    result.Marked,
+		Restored:  result.Restored,
+		Protected: result.Protected,
+		Actions:   result.Actions,
+	}
+}
+
+// writeReport writes the run report to REPORT_OUTPUT
+func writeReport(env *environment, runReport *report.Report) error {
+	switch env.setting.ReportOutput {
+	case settings.ReportOutputStdout:
+		return runReport.Write(os.Stdout)
+	case settings.ReportOutputFile:
+		return runReport.WriteFile(env.setting.ReportFile)
+	case settings.ReportOutputConfigMap:
+		return runReport.WriteConfigMap(context.WithoutCancel(env.ctx), env.kubernetesClient.Client(), env.setting.KubernetesConfigMapNamepace, env.setting.ReportConfigMapName)
+	}
+	return nil
+}
+
+func runPlan(env *environment) error {
+	return env.forEachTarget(func(name string, s *syncer.Syncer) error {
+		plan, err := s.Plan(env.ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Plan for %s:\n", name)
+		for _, service := range plan.Create {
+			fmt.Printf("  + create prefix for service %s/%s (%s)\n", service.Namespace, service.Name, service.ExternalIPs)
+		}
+		action := "delete"
+		if s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyRetain {
+			action = "retain"
+		}
+		for _, prefix := range plan.Delete {
+			fmt.Printf("  - %s prefix %s (id %d) of service %s/%s\n", action, prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName)
+		}
+		mark := "deprecate"
+		if s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyTag {
+			mark = "tag"
+		}
+		for _, prefix := range plan.Mark {
+			fmt.Printf("  ~ %s prefix %s (id %d) of service %s/%s\n", mark, prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName)
+		}
+		for _, prefix := range plan.Marked {
+			until := ""
+			if s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyDestroy {
+				until = " until " + prefix.RemovedAt.Add(s.Settings.NetboxDeletionGracePeriod).UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("  ~ keep marked prefix %s (id %d) of service %s/%s%s\n", prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName, until)
+		}
+		for _, prefix := range plan.Restore {
+			fmt.Printf("  ~ restore prefix %s (id %d) of service %s/%s\n", prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName)
+		}
+		for _, prefix := range plan.Protected {
+			fmt.Printf("  = retain protected prefix %s (id %d) of service %s/%s, protected by %s\n", prefix.Prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName, prefix.Reason)
+		}
+		fmt.Printf("  %d to create, %d to %s\n", len(plan.Create), len(plan.Delete), action)
+		if len(plan.Protected) > 0 {
+			fmt.Printf("  %d protected\n", len(plan.Protected))
+		}
+		if len(plan.Mark)+len(plan.Marked)+len(plan.Restore) > 0 {
+			fmt.Printf("  %d to %s, %d marked, %d to restore\n", len(plan.Mark), mark, len(plan.Marked), len(plan.Restore))
+		}
+		if err := s.CheckDeletionGuard(plan); err != nil {
+			fmt.Printf("  ! %v\n", err)
+		}
+
+		return nil
+	})
+}
+
+func runList(env *environment) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TARGET\tNAMESPACE\tSERVICE\tEXTERNAL-IP\tPREFIX\tID\tURL")
+
+	err := env.forEachTarget(func(name string, s *syncer.Syncer) error {
+		prefixes, err := s.State.Load(env.ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, prefix := range prefixes {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", name, prefix.Namespace, prefix.ServiceName, prefix.ExternalIPs, prefix.Prefix, prefix.PrefixID, s.Netbox.PrefixURL(prefix.PrefixID))
+		}
+		return nil
+	})
+
+	writer.Flush()
+	return err
+}
+
+func runVerify(env *environment) error {
+	drifted := 0
+
+	err := env.forEachTarget(func(name string, s *syncer.Syncer) error {
+		drift, err := s.Verify(env.ctx)
+		for _, message := range drift {
+			fmt.Printf("%s: %s\n", name, message)
+		}
+		drifted += len(drift)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if drifted > 0 {
+		return fmt.Errorf("found %d drifts", drifted)
+	}
+	fmt.Println("No drift found")
+	return nil
+}
+
+func runGC(env *environment) error {
+	return env.forEachTarget(func(name string, s *syncer.Syncer) error {
+		result, err := s.GC(env.ctx, env.dryRun)
+		slog.Info("Found orphaned prefixes", "target", name, "count", len(result.Orphaned), "deleted", result.Deleted, "marked", result.Marked, "protected", result.Protected)
+		return err
+	})
+}
+
+func runRecover(env *environment) error {
+	return env.forEachTarget(func(name string, s *syncer.Syncer) error {
+		slog.Info("Recovering state", "target", name)
+		_, err := s.Recover(env.ctx, env.dryRun)
+		return err
+	})
+}
+
+func runExport(env *environment) error {
+	if env.file == "" {
+		return fmt.Errorf("--file is required")
+	}
+
+	s, shutdown, err := env.singleTarget()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	prefixes, err := s.State.Load(env.ctx)
+	if err != nil {
+		return fmt.Errorf("cannot load existing state: %v", err)
+	}
+
+	data, err := state.Export(s.Settings.KubernetesCluster, prefixes)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(env.file, data, 0o600)
+	if err != nil {
+		return err
+	}
+	slog.Info("Exported state", "file", env.file, "count", len(prefixes))
+
+	return nil
+}
+
+func runImport(env *environment) error {
+	if env.file == "" {
+		return fmt.Errorf("--file is required")
+	}
+
+	s, shutdown, err := env.singleTarget()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	data, err := os.ReadFile(env.file)
+	if err != nil {
+		return err
+	}
+
+	prefixes, err := state.Import(data, s.Settings.KubernetesCluster)
+	if err != nil {
+		return fmt.Errorf("invalid state in %s: %v", env.file, err)
+	}
+
+	// the stores only write over the state they loaded
+	if _, err := s.State.Load(env.ctx); err != nil {
+		return fmt.Errorf("cannot load existing state: %v", err)
+	}
+
+	err = s.State.Save(env.ctx, prefixes)
+	if err != nil {
+		return fmt.Errorf("error saving prefixes to state: %v", err)
+	}
+	slog.Info("Imported state", "file", env.file, "count", len(prefixes))
+
+	return nil
+}
+
+func runAudit(env *environment) error {
+	netboxClient, err := client.NewNetboxClient(env.setting)
+	if err != nil {
+		return fmt.Errorf("error initializing Netbox client: %v", err)
+	}
+
+	journal, err := audit.NewJournal(env.setting, env.kubernetesClient, netboxClient)
+	if err != nil {
+		return err
+	}
+	if journal == nil {
+		return fmt.Errorf("no audit journal configured, set AUDIT_JOURNAL")
+	}
+
+	entries, err := journal.Read(env.ctx, env.query)
+	if err != nil {
+		return fmt.Errorf("error reading the audit journal: %v", err)
+	}
+
+	if env.json {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TIME\tRUN\tCLUSTER\tACTION\tOUTCOME\tNAMESPACE\tSERVICE\tIP\tPREFIX\tID")
+	for _, entry := range entries {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n", entry.Time.Format(time.RFC3339), entry.RunID, entry.Cluster, entry.Action, entry.Outcome, entry.Namespace, entry.Service, entry.IP, entry.Prefix, entry.ObjectID)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,69 @@
+// Package logging configures the structured logger shared by the whole run.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// RequestIDHeader carries the run ID on every Netbox API request, so the
+// changes of a run can be traced in the Netbox request logs
+const RequestIDHeader = "X-Request-ID"
+
+// Attribute keys shared by every log line
+const (
+	KeyRunID     = "run_id"
+	KeyCluster   = "cluster"
+	KeyNamespace = "namespace"
+	KeyService   = "service"
+	KeyIP        = "ip"
+	KeyPrefix    = "prefix"
+	KeyNetboxID  = "netbox_id"
+	KeyError     = "error"
+)
+
+var runID = newRunID()
+
+// RunID returns the ID of this run, unique per process
+func RunID() string {
+	return runID
+}
+
+// Setup makes the default slog logger write to stderr with the level and
+// format, text or json, and tag every line with the run ID
+func Setup(level string, format string) error {
+	return setup(os.Stderr, level, format)
+}
+
+func setup(w io.Writer, level string, format string) error {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(level))
+	if err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	options := &slog.HandlerOptions{Level: l}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(handler).With(KeyRunID, runID))
+	return nil
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
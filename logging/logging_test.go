@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name      string
+		level     string
+		format    string
+		expectErr bool
+	}{
+		{"JSON", "info", "json", false},
+		{"Text", "debug", "text", false},
+		{"Upper case", "WARN", "JSON", false},
+		{"Invalid level", "verbose", "json", true},
+		{"Invalid format", "info", "xml", true},
+	}
+
+	defer slog.SetDefault(slog.Default())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := setup(&bytes.Buffer{}, tt.level, tt.format)
+			if tt.expectErr && err == nil {
+				t.Error("setup expected error but got none")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("setup unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSetupRunID(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var buf bytes.Buffer
+	if err := setup(&buf, "info", "json"); err != nil {
+		t.Fatal(err)
+	}
+	slog.Debug("hidden")
+	slog.Info("Created prefix", KeyCluster, "prod-a", KeyNetboxID, 7)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if line[KeyRunID] != RunID() {
+		t.Errorf("run_id = %v, expected %s", line[KeyRunID], RunID())
+	}
+	if line[KeyCluster] != "prod-a" || line[KeyNetboxID] != float64(7) {
+		t.Errorf("line = %v, expected the cluster and Netbox ID attributes", line)
+	}
+}
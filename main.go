@@ -1,115 +1,414 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/api/v1alpha1"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/audit"
 	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
-	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/metrics"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/report"
 	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/state"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/syncer"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// environment is what a command runs with, the settings after the flags
+// were applied and the client of the cluster the syncer runs in. ctx is done
+// on SIGTERM, SIGINT or after RUN_TIMEOUT.
+type environment struct {
+	ctx              context.Context
+	setting          settings.Settings
+	kubernetesClient *client.KubernetesClient
+	target           string
+	dryRun           bool
+	file             string
+	query            audit.Query
+	json             bool
+}
+
+// target is a cluster a command operates on: the cluster of the settings, a
+// cluster of the clusters file or a NetboxSyncPolicy
+type target struct {
+	name  string
+	build func() (*syncer.Syncer, func(), error)
+}
+
 func main() {
-	setting, err := settings.NewSettings()
-	if err != nil {
-		log.Fatalf("Error loading settings: %v", err)
+	name, args := "sync", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	fmt.Println("Loaded settings")
+	if name == "help" {
+		usage()
+		return
+	}
 
-	netboxClient, err := client.NewNetboxClient(setting)
-	if err != nil {
-		log.Fatalf("Error initializing Netbox client: %v", err)
+	cmd, found := commands[name]
+	if !found {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
 	}
-	fmt.Println("Initialized Netbox client")
 
-	kubernetesClient, err := client.NewKubernetesClient(setting)
-	if err != nil {
-		log.Fatalf("Error initializing Kubernetes client: %v", err)
+	var env environment
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: kubernetes-service-netbox-syncer %s [flags]\n\n%s\n\nFlags:\n", name, cmd.description)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&env.target, "target", "", "only operate on this cluster of the clusters file or this NetboxSyncPolicy")
+	if cmd.dryRun {
+		flags.BoolVar(&env.dryRun, "dry-run", false, "only print what would change")
+	}
+	if cmd.file != "" {
+		flags.StringVar(&env.file, "file", "", cmd.file)
+	}
+	if cmd.query {
+		flags.StringVar(&env.query.Service, "service", "", "only show the changes of this service, name or namespace/name")
+		flags.StringVar(&env.query.IP, "ip", "", "only show the changes of this external IP, hostname or prefix address")
+		flags.BoolVar(&env.json, "json", false, "print the entries as JSON lines, with the records before and after each change")
 	}
-	fmt.Println("Initialized Kubernetes client")
+	settings.BindFlags(flags)
+	flags.Parse(args)
 
-	// fetch the exisitng prefixes
-	existingPrefixes, err := kubernetesClient.CreateOrLoadConfiMap()
+	setting, err := settings.NewSettings()
 	if err != nil {
-		log.Fatalf("cannot create or load exisitng configmap: %v", err)
+		fatal("Failed to load settings", err)
 	}
+	env.setting = setting
 
-	services, err := kubernetesClient.GetKubernetesService()
-	if err != nil {
-		log.Fatalf("Error fetching Kubernetes services: %v", err)
+	if err := logging.Setup(setting.LogLevel, setting.LogFormat); err != nil {
+		fatal("Failed to set up logging", err)
 	}
-	fmt.Printf("Fetched %d Kubernetes services\n", len(services))
+	slog.Info("Loaded settings", "command", name)
 
-	// Build a map of existing External IPs for quick lookup
-	existingIPMap := make(map[string]model.Prefix)
-	for _, prefix := range existingPrefixes {
-		existingIPMap[prefix.ExternalIPs] = prefix
+	if setting.MetricsAddress != "" {
+		if err := metrics.Serve(setting.MetricsAddress); err != nil {
+			fatal("Failed to serve metrics", err)
+		}
+		slog.Info("Serving metrics", "address", setting.MetricsAddress)
 	}
 
-	// Build a map of current service External IPs
-	serviceIPMap := make(map[string]model.KubernetesService)
-	for _, service := range services {
-		serviceIPMap[service.ExternalIPs] = service
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	if setting.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, setting.RunTimeout, fmt.Errorf("RUN_TIMEOUT of %s exceeded", setting.RunTimeout))
+		defer cancel()
 	}
+	env.ctx = ctx
 
-	// Find services to create (in Kubernetes but not in Netbox)
-	var createdServices []model.KubernetesService
-	for _, service := range services {
-		if _, exists := existingIPMap[service.ExternalIPs]; !exists {
-			createdServices = append(createdServices, service)
+	// log the signal once, the commands stop starting changes on their own
+	go func() {
+		<-ctx.Done()
+		if ctx.Err() == context.Canceled {
+			slog.Warn("Received termination signal, finishing the changes in flight")
 		}
+		stop()
+	}()
+
+	kubernetesClient, err := client.NewKubernetesClient(setting)
+	if err != nil {
+		fatal("Failed to initialize Kubernetes client", err)
 	}
+	slog.Debug("Initialized Kubernetes client")
+	env.kubernetesClient = kubernetesClient
 
-	// Create prefixes in Netbox for new services
-	for _, service := range createdServices {
-		fmt.Printf("Creating prefix for service: %s/%s (%s)\n", service.Namespace, service.Name, service.ExternalIPs)
-		prefixes, err := netboxClient.CreatePrefix(service)
+	err = cmd.run(&env)
+	kubernetesClient.Shutdown()
+	if errors.Is(err, errPartial) {
+		slog.Warn("Completed "+name+" with failures", logging.KeyError, err)
+		os.Exit(report.ExitPartial)
+	}
+	if err != nil {
+		fatal("Failed to run "+name, err)
+	}
+}
+
+// errPartial is returned by a command that completed with failures, the
+// process then exits with report.ExitPartial instead of report.ExitFailed
+var errPartial = errors.New("completed with failures")
+
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.KeyError, err)
+	os.Exit(report.ExitFailed)
+}
+
+// targets returns the clusters the command operates on, in policy mode one
+// per NetboxSyncPolicy and in multi-cluster mode one per cluster definition
+func (env *environment) targets() ([]target, error) {
+	var targets []target
+
+	switch {
+	case env.setting.KubernetesSyncPolicies:
+		policies, err := env.kubernetesClient.ListSyncPolicies(env.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing NetboxSyncPolicies: %v", err)
+		}
+		slog.Info("Loaded NetboxSyncPolicies", "count", len(policies))
+		conflicts := settings.PolicyConflicts(env.setting, policies)
+
+		for _, policy := range policies {
+			targets = append(targets, target{
+				name: policy.Name,
+				build: func() (*syncer.Syncer, func(), error) {
+					if err := conflicts[policy.Name]; err != nil {
+						return nil, nil, err
+					}
+					s, err := newPolicySyncer(env.setting, policy, env.kubernetesClient)
+					return s, func() {}, err
+				},
+			})
+		}
+
+	case env.setting.KubernetesClustersFile != "":
+		clusters, err := settings.LoadClusters(env.setting.KubernetesClustersFile)
 		if err != nil {
-			log.Printf("Error creating prefix in Netbox for service %s/%s: %v", service.Namespace, service.Name, err)
-		} else {
-			fmt.Printf("Created prefix in Netbox for service %s/%s\n", service.Namespace, service.Name)
-			// Add newly created prefixes to existing list
-			existingPrefixes = append(existingPrefixes, prefixes...)
-			for _, prefix := range prefixes {
-				existingIPMap[prefix.ExternalIPs] = prefix
-			}
+			return nil, fmt.Errorf("error loading clusters: %v", err)
 		}
+		slog.Info("Loaded clusters", "count", len(clusters))
+
+		for _, cluster := range clusters {
+			targets = append(targets, target{
+				name: cluster.Name,
+				build: func() (*syncer.Syncer, func(), error) {
+					s, err := newClusterSyncer(env.ctx, env.setting.ForCluster(cluster), cluster, env.kubernetesClient)
+					if err != nil {
+						return nil, nil, err
+					}
+					return s, s.Kubernetes.Shutdown, nil
+				},
+			})
+		}
+
+	default:
+		targets = append(targets, target{
+			name: env.setting.KubernetesCluster,
+			build: func() (*syncer.Syncer, func(), error) {
+				s, err := newSyncer(env.setting, env.kubernetesClient)
+				return s, func() {}, err
+			},
+		})
 	}
 
-	// Find prefixes to delete (in Netbox but not in Kubernetes)
-	var deletedPrefixes []model.Prefix
-	for _, existingPrefix := range existingPrefixes {
-		if _, exists := serviceIPMap[existingPrefix.ExternalIPs]; !exists {
-			deletedPrefixes = append(deletedPrefixes, existingPrefix)
+	if env.target == "" {
+		return targets, nil
+	}
+	var names []string
+	for _, t := range targets {
+		if t.name == env.target {
+			return []target{t}, nil
 		}
+		names = append(names, t.name)
+	}
+	return nil, unknownTarget(env.target, names)
+}
+
+// unknownTarget returns the error of a --target that names none of the
+// targets, listing the valid ones
+func unknownTarget(name string, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("target %s not found, no targets configured", name)
 	}
+	return fmt.Errorf("target %s not found, valid targets: %s", name, strings.Join(names, ", "))
+}
 
-	// Delete stale prefixes from Netbox
-	deletedPrefixIDs := make(map[int32]bool)
-	for _, deletedPrefix := range deletedPrefixes {
-		err := netboxClient.DeletePrefix(deletedPrefix.PrefixID)
+// forEachTarget runs fn for every target. Each target is handled
+// independently, a failing target is skipped without affecting the others.
+func (env *environment) forEachTarget(fn func(name string, s *syncer.Syncer) error) error {
+	targets, err := env.targets()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, t := range targets {
+		s, shutdown, err := t.build()
+		if err == nil {
+			err = fn(t.name, s)
+			shutdown()
+		}
 		if err != nil {
-			log.Printf("Error deleting prefix %d from Netbox: %v", deletedPrefix.PrefixID, err)
-		} else {
-			fmt.Printf("Deleted prefix %d from Netbox\n", deletedPrefix.PrefixID)
-			deletedPrefixIDs[deletedPrefix.PrefixID] = true
+			slog.Error("Failed to run target", "target", t.name, logging.KeyError, err)
+			failed++
 		}
 	}
 
-	// Build updated prefixes list (existing prefixes minus deleted ones)
-	var updatedPrefixes []model.Prefix
-	for _, prefix := range existingPrefixes {
-		if !deletedPrefixIDs[prefix.PrefixID] {
-			updatedPrefixes = append(updatedPrefixes, prefix)
-		}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets failed", failed, len(targets))
 	}
+	return nil
+}
 
-	fmt.Printf("Updating ConfigMap with %d prefixes\n", len(updatedPrefixes))
+// singleTarget returns the syncer of the only target the command operates
+// on, commands moving a whole state need exactly one
+func (env *environment) singleTarget() (*syncer.Syncer, func(), error) {
+	targets, err := env.targets()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(targets) != 1 {
+		return nil, nil, fmt.Errorf("%d targets configured, select one with --target", len(targets))
+	}
+	return targets[0].build()
+}
 
-	// update the latest prefixes to configmap
-	err = kubernetesClient.SavePrefixToConfigMap(updatedPrefixes)
+func newSyncer(setting settings.Settings, kubernetesClient *client.KubernetesClient) (*syncer.Syncer, error) {
+	netboxClient, err := client.NewNetboxClient(setting)
 	if err != nil {
-		log.Printf("Error saving prefix to ConfigMap: %v", err)
+		return nil, fmt.Errorf("error initializing Netbox client: %v", err)
 	}
+	slog.Debug("Initialized Netbox client", logging.KeyCluster, setting.KubernetesCluster)
+
+	store, err := state.NewStore(setting, kubernetesClient, netboxClient)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing state store: %v", err)
+	}
+
+	journal, err := audit.NewJournal(setting, kubernetesClient, netboxClient)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing audit journal: %v", err)
+	}
+
+	return &syncer.Syncer{
+		Settings:   setting,
+		Kubernetes: kubernetesClient,
+		State:      store,
+		Netbox:     netboxClient,
+		Journal:    journal,
+	}, nil
+}
+
+// newClusterSyncer builds the syncer of one cluster definition, keeping its
+// state in the cluster the syncer runs in or in Netbox. The caller shuts
+// down its Kubernetes client.
+func newClusterSyncer(ctx context.Context, setting settings.Settings, cluster settings.Cluster, localClient *client.KubernetesClient) (*syncer.Syncer, error) {
+	netboxClient, err := client.NewNetboxClient(setting)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Netbox client: %v", err)
+	}
+
+	clusterClient, err := client.NewKubernetesClientForCluster(ctx, setting, cluster, localClient)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Kubernetes client: %v", err)
+	}
+
+	store, err := state.NewStore(setting, localClient, netboxClient)
+	if err != nil {
+		clusterClient.Shutdown()
+		return nil, fmt.Errorf("error initializing state store: %v", err)
+	}
+
+	// the journal is kept in the cluster the syncer runs in, like the state
+	journal, err := audit.NewJournal(setting, localClient, netboxClient)
+	if err != nil {
+		clusterClient.Shutdown()
+		return nil, fmt.Errorf("error initializing audit journal: %v", err)
+	}
+
+	return &syncer.Syncer{
+		Settings:   setting,
+		Kubernetes: clusterClient,
+		State:      store,
+		Netbox:     netboxClient,
+		Journal:    journal,
+	}, nil
+}
+
+func newPolicySyncer(setting settings.Settings, policy v1alpha1.NetboxSyncPolicy, kubernetesClient *client.KubernetesClient) (*syncer.Syncer, error) {
+	policySetting, err := setting.ForPolicy(policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec: %v", err)
+	}
+
+	return newSyncer(policySetting, kubernetesClient.WithSettings(policySetting))
+}
+
+// syncPolicies syncs the cluster once per NetboxSyncPolicy and reports the
+// outcome in the status of each policy and in the run report
+func syncPolicies(env *environment, runReport *report.Report) error {
+	policies, err := env.kubernetesClient.ListSyncPolicies(env.ctx)
+	if err != nil {
+		return fmt.Errorf("error listing NetboxSyncPolicies: %v", err)
+	}
+	slog.Info("Loaded NetboxSyncPolicies", "count", len(policies))
+
+	if env.target != "" {
+		var names []string
+		for _, policy := range policies {
+			names = append(names, policy.Name)
+		}
+		if !slices.Contains(names, env.target) {
+			return unknownTarget(env.target, names)
+		}
+	}
+
+	conflicts := settings.PolicyConflicts(env.setting, policies)
+
+	for _, policy := range policies {
+		if env.target != "" && policy.Name != env.target {
+			continue
+		}
+		log := slog.With("policy", policy.Name)
+		log.Info("Syncing NetboxSyncPolicy")
+
+		var result syncer.Result
+		var s *syncer.Syncer
+		err := conflicts[policy.Name]
+		if err == nil {
+			s, err = newPolicySyncer(env.setting, policy, env.kubernetesClient)
+		}
+		if err == nil {
+			result, err = s.Run(env.ctx)
+		}
+		if err != nil {
+			log.Error("Failed to sync NetboxSyncPolicy", logging.KeyError, err)
+		}
+		runReport.AddTarget(targetReport(policy.Name, result), err)
+
+		now := metav1.Now()
+		policy.Status.ObservedGeneration = policy.Generation
+		policy.Status.LastSyncTime = &now
+		policy.Status.ObjectCount = int32(result.Prefixes)
+		policy.Status.Errors = result.Errors
+
+		condition := metav1.Condition{
+			Type:               v1alpha1.ConditionSynced,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: policy.Generation,
+			Reason:             "SyncSucceeded",
+			Message:            fmt.Sprintf("Synced %d prefixes", result.Prefixes),
+		}
+		if err != nil {
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "SyncFailed"
+			condition.Message = err.Error()
+			policy.Status.Errors = append(policy.Status.Errors, err.Error())
+		} else if len(result.Errors) > 0 {
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "PartialSync"
+			condition.Message = fmt.Sprintf("Synced %d prefixes with %d errors", result.Prefixes, len(result.Errors))
+		}
+		meta.SetStatusCondition(&policy.Status.Conditions, condition)
+
+		if err := env.kubernetesClient.UpdateSyncPolicyStatus(context.WithoutCancel(env.ctx), policy); err != nil {
+			log.Error("Failed to update status of NetboxSyncPolicy", logging.KeyError, err)
+		}
+	}
+
+	return nil
 }
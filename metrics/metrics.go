@@ -0,0 +1,126 @@
+// Package metrics exposes the Prometheus metrics of the sync runs.
+package metrics
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const namespace = "netbox_syncer"
+
+// Registry holds the syncer metrics only, without the Go runtime metrics, so
+// that the same set is served and pushed
+var Registry = prometheus.NewRegistry()
+
+var (
+	ServicesDiscovered = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "services_discovered",
+		Help:      "Number of Kubernetes services selected for sync in the last run.",
+	}, []string{"cluster"})
+
+	ObjectsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "objects_created_total",
+		Help:      "Number of Netbox objects created.",
+	}, []string{"cluster"})
+
+	ObjectsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "objects_deleted_total",
+		Help:      "Number of Netbox objects deleted.",
+	}, []string{"cluster"})
+
+	ObjectsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "objects_failed_total",
+		Help:      "Number of Netbox objects that failed to be created, deleted, marked or restored.",
+	}, []string{"cluster", "operation"})
+
+	DNSResolutionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dns_resolution_failures_total",
+		Help:      "Number of external hostnames of services that failed to resolve.",
+	}, []string{"cluster"})
+
+	NetboxRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "netbox_request_duration_seconds",
+		Help:      "Latency of the Netbox API requests by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	NetboxRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "netbox_retries_total",
+		Help:      "Number of Netbox API requests retried after a transient error, by status code.",
+	}, []string{"code"})
+
+	StateRecords = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "state_records",
+		Help:      "Number of records in the state after the last run.",
+	}, []string{"cluster"})
+
+	StateSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "state_size_bytes",
+		Help:      "Size of the last written state, after compression.",
+	}, []string{"cluster"})
+
+	LastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last sync run that completed without errors.",
+	}, []string{"cluster"})
+)
+
+func init() {
+	Registry.MustRegister(
+		ServicesDiscovered,
+		ObjectsCreated,
+		ObjectsDeleted,
+		ObjectsFailed,
+		DNSResolutionFailures,
+		NetboxRequestDuration,
+		NetboxRetries,
+		StateRecords,
+		StateSize,
+		LastSuccess,
+	)
+}
+
+// InstrumentRoundTripper records the latency and status code of every
+// request made through the round tripper
+func InstrumentRoundTripper(next http.RoundTripper) http.RoundTripper {
+	return promhttp.InstrumentRoundTripperDuration(NetboxRequestDuration, next)
+}
+
+// Serve serves the metrics on /metrics of the address in the background
+func Serve(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
+
+	server := &http.Server{Addr: address, Handler: mux}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	go server.Serve(listener)
+	return nil
+}
+
+// Push adds the metrics to the Pushgateway group of the job and instance. Only
+// the pushed metrics are replaced, so a failed run keeps the last success
+// timestamp of the previous one.
+func Push(url string, job string, instance string) error {
+	return push.New(url, job).
+		Gatherer(Registry).
+		Grouping("instance", instance).
+		Add()
+}
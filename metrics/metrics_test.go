@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPush(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	LastSuccess.WithLabelValues("prod-a").SetToCurrentTime()
+
+	err := Push(server.URL, "netbox-syncer", "prod-a")
+	if err != nil {
+		t.Fatalf("Push unexpected error: %v", err)
+	}
+
+	// Add keeps the metrics of the group that are not pushed
+	if method != http.MethodPost {
+		t.Errorf("Push used %s, expected POST", method)
+	}
+	if path != "/metrics/job/netbox-syncer/instance/prod-a" {
+		t.Errorf("Push path = %s, expected the job and instance group", path)
+	}
+	if !strings.Contains(body, "netbox_syncer_last_success_timestamp_seconds") {
+		t.Errorf("Push body does not contain the last success timestamp")
+	}
+}
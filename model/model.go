@@ -1,15 +1,46 @@
 package model
 
+import "time"
+
+// StateSchemaVersion is the version of the State written by this syncer
+const StateSchemaVersion = 1
+
+// ObjectTypePrefix is the Netbox object type of a Prefix record
+const ObjectTypePrefix = "ipam.prefix"
+
+// State is the versioned envelope of the records persisted between runs
+type State struct {
+	SchemaVersion int       `json:"schema_version"`
+	Cluster       string    `json:"cluster"`
+	LastRun       time.Time `json:"last_run"`
+	Records       []Prefix  `json:"records"`
+}
+
 type Prefix struct {
-	PrefixID    int32  `json:"prefix_id"`
-	Prefix      string `json:"prefix"`
-	ExternalIPs string `json:"dns"`
-	ServiceName string `json:"service_name"`
-	Namespace   string `json:"namespace"`
+	PrefixID    int32     `json:"prefix_id"`
+	Prefix      string    `json:"prefix"`
+	ExternalIPs string    `json:"external_ip"`
+	ServiceName string    `json:"service_name"`
+	Namespace   string    `json:"namespace"`
+	Cluster     string    `json:"cluster"`
+	ObjectType  string    `json:"object_type"`
+	CreatedAt   time.Time `json:"created_at,omitzero"`
+	UpdatedAt   time.Time `json:"updated_at,omitzero"`
+	// RemovedAt is when the prefix was marked because its service was
+	// removed, zero while the service exists
+	RemovedAt time.Time `json:"removed_at,omitzero"`
+	// Protected is set when the service carries the protect annotation, the
+	// prefix is then retained instead of deleted once the service is removed
+	Protected bool `json:"protected,omitempty"`
+	// Retained is set when the service was removed and the prefix protected
+	// from deletion, it stays in the state until its service comes back
+	Retained bool `json:"retained,omitempty"`
 }
 
 type KubernetesService struct {
+	UID         string
 	Name        string
 	Namespace   string
 	ExternalIPs string
+	Protected   bool
 }
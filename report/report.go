@@ -0,0 +1,227 @@
+// Package report summarizes a sync run for machines: every action taken on
+// Netbox, its outcome, and the exit code of the process.
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Status is the outcome of a run or of one of its targets
+type Status string
+
+const (
+	StatusSucceeded Status = "succeeded"
+	StatusPartial   Status = "partial"
+	StatusFailed    Status = "failed"
+)
+
+// Exit codes of the process, a partial failure is told apart from a run
+// that could not complete at all
+const (
+	ExitSucceeded = 0
+	ExitFailed    = 1
+	ExitPartial   = 2
+)
+
+// Outcomes of an action
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	// OutcomeBlocked is a deletion held back by the deletion guard
+	OutcomeBlocked = "blocked"
+	// OutcomeProtected is a deletion skipped because the object is protected
+	OutcomeProtected = "protected"
+)
+
+// Operations of an action
+const (
+	OperationCreate       = "create"
+	OperationDelete       = "delete"
+	OperationRetain       = "retain"
+	OperationDeprecate    = "deprecate"
+	OperationTag          = "tag"
+	OperationRestore      = "restore"
+	OperationSaveState    = "save_state"
+	OperationWriteJournal = "write_journal"
+)
+
+// key of the report in the report ConfigMap
+const configMapKey = "report.json"
+
+// Action is a single change made, or attempted, during a run
+type Action struct {
+	Operation string `json:"operation"`
+	Namespace string `json:"namespace,omitempty"`
+	Service   string `json:"service,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	NetboxID  int32  `json:"netbox_id,omitempty"`
+	Outcome   string `json:"outcome"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Target is the outcome of a run for one cluster or NetboxSyncPolicy. Error
+// is set when the target could not be synced at all.
+type Target struct {
+	Name      string   `json:"name"`
+	Status    Status   `json:"status"`
+	Prefixes  int      `json:"prefixes"`
+	Created   int      `json:"created"`
+	Deleted   int      `json:"deleted"`
+	Marked    int      `json:"marked,omitempty"`
+	Restored  int      `json:"restored,omitempty"`
+	Protected int      `json:"protected,omitempty"`
+	Actions   []Action `json:"actions"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Report is the summary of a run. Error is set when the run failed before
+// any target was synced.
+type Report struct {
+	RunID      string    `json:"run_id"`
+	Command    string    `json:"command"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Status     Status    `json:"status"`
+	Targets    []Target  `json:"targets"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// New starts the report of a run
+func New(runID string, command string) *Report {
+	return &Report{
+		RunID:     runID,
+		Command:   command,
+		StartedAt: time.Now().UTC(),
+		Targets:   []Target{},
+	}
+}
+
+// AddTarget records the outcome of a target, deriving its status from the
+// error and the outcome of its actions
+func (r *Report) AddTarget(target Target, err error) {
+	if target.Actions == nil {
+		target.Actions = []Action{}
+	}
+
+	target.Status = StatusSucceeded
+	for _, action := range target.Actions {
+		if action.Outcome == OutcomeFailed {
+			target.Status = StatusPartial
+		}
+	}
+	if err != nil {
+		target.Status = StatusFailed
+		target.Error = err.Error()
+	}
+
+	r.Targets = append(r.Targets, target)
+}
+
+// Finish completes the report. err is the error that stopped the run, if
+// any. The run failed when it was stopped or when every target failed, and
+// is partial when only some targets or actions failed.
+func (r *Report) Finish(err error) {
+	r.FinishedAt = time.Now().UTC()
+
+	if err != nil {
+		r.Status = StatusFailed
+		r.Error = err.Error()
+		return
+	}
+
+	failed := 0
+	r.Status = StatusSucceeded
+	for _, target := range r.Targets {
+		switch target.Status {
+		case StatusFailed:
+			failed++
+			r.Status = StatusPartial
+		case StatusPartial:
+			r.Status = StatusPartial
+		}
+	}
+	if failed > 0 && failed == len(r.Targets) {
+		r.Status = StatusFailed
+	}
+}
+
+// ExitCode returns the exit code of the process for the status of the run
+func (r *Report) ExitCode() int {
+	switch r.Status {
+	case StatusSucceeded:
+		return ExitSucceeded
+	case StatusPartial:
+		return ExitPartial
+	default:
+		return ExitFailed
+	}
+}
+
+// Write writes the report as indented JSON
+func (r *Report) Write(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteFile writes the report to a file, replacing the report of the
+// previous run
+func (r *Report) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = r.Write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// WriteConfigMap writes the report to the report.json key of a ConfigMap,
+// creating it when missing
+func (r *Report) WriteConfigMap(ctx context.Context, client kubernetes.Interface, namespace string, name string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.CoreV1().ConfigMaps(namespace).Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Data: map[string]string{
+				configMapKey: string(data),
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[configMapKey] = string(data)
+	_, err = client.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
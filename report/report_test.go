@@ -0,0 +1,145 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFinish(t *testing.T) {
+	succeeded := Action{Operation: OperationCreate, Outcome: OutcomeSucceeded}
+	failed := Action{Operation: OperationDelete, Outcome: OutcomeFailed, Error: "boom"}
+
+	type target struct {
+		actions []Action
+		err     error
+	}
+
+	tests := []struct {
+		name     string
+		targets  []target
+		err      error
+		status   Status
+		exitCode int
+	}{
+		{
+			name:     "no targets",
+			status:   StatusSucceeded,
+			exitCode: ExitSucceeded,
+		},
+		{
+			name:     "all actions succeeded",
+			targets:  []target{{actions: []Action{succeeded}}, {actions: []Action{succeeded}}},
+			status:   StatusSucceeded,
+			exitCode: ExitSucceeded,
+		},
+		{
+			name:     "failed action",
+			targets:  []target{{actions: []Action{succeeded, failed}}},
+			status:   StatusPartial,
+			exitCode: ExitPartial,
+		},
+		{
+			name:     "one of two targets failed",
+			targets:  []target{{actions: []Action{succeeded}}, {err: errors.New("unreachable")}},
+			status:   StatusPartial,
+			exitCode: ExitPartial,
+		},
+		{
+			name:     "every target failed",
+			targets:  []target{{err: errors.New("unreachable")}, {err: errors.New("unreachable")}},
+			status:   StatusFailed,
+			exitCode: ExitFailed,
+		},
+		{
+			name:     "run stopped",
+			err:      errors.New("cannot list targets"),
+			status:   StatusFailed,
+			exitCode: ExitFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New("run", "sync")
+			for _, target := range tt.targets {
+				r.AddTarget(Target{Name: "cluster", Actions: target.actions}, target.err)
+			}
+			r.Finish(tt.err)
+
+			if r.Status != tt.status {
+				t.Errorf("Status = %q, want %q", r.Status, tt.status)
+			}
+			if r.ExitCode() != tt.exitCode {
+				t.Errorf("ExitCode() = %d, want %d", r.ExitCode(), tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestAddTarget(t *testing.T) {
+	r := New("run", "sync")
+	r.AddTarget(Target{Name: "cluster-1"}, nil)
+	r.AddTarget(Target{Name: "cluster-2", Actions: []Action{{Outcome: OutcomeFailed}}}, nil)
+	r.AddTarget(Target{Name: "cluster-3"}, errors.New("unreachable"))
+
+	want := []Status{StatusSucceeded, StatusPartial, StatusFailed}
+	for i, target := range r.Targets {
+		if target.Status != want[i] {
+			t.Errorf("Targets[%d].Status = %q, want %q", i, target.Status, want[i])
+		}
+	}
+	if r.Targets[2].Error != "unreachable" {
+		t.Errorf("Targets[2].Error = %q, want %q", r.Targets[2].Error, "unreachable")
+	}
+}
+
+func TestWriteConfigMap(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	for _, runID := range []string{"first", "second"} {
+		r := New(runID, "sync")
+		r.Finish(nil)
+		if err := r.WriteConfigMap(t.Context(), client, "default", "report"); err != nil {
+			t.Fatalf("WriteConfigMap() error = %v", err)
+		}
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps("default").Get(t.Context(), "report", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	var r Report
+	if err := json.Unmarshal([]byte(configMap.Data[configMapKey]), &r); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	if r.RunID != "second" {
+		t.Errorf("RunID = %q, want the last run", r.RunID)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := New("run", "sync")
+	r.AddTarget(Target{Name: "cluster"}, nil)
+	r.Finish(nil)
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	for _, key := range []string{"run_id", "command", "started_at", "finished_at", "status", "targets"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("missing key %q", key)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package settings
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// Cluster describes one Kubernetes cluster synced by a central syncer. Unset
+// filters inherit the value from the environment.
+type Cluster struct {
+	Name             string            `json:"name"`
+	Kubeconfig       string            `json:"kubeconfig,omitempty"`
+	Context          string            `json:"context,omitempty"`
+	KubeconfigSecret *KubeconfigSecret `json:"kubeconfigSecret,omitempty"`
+	ConfigMapName    string            `json:"configMapName,omitempty"`
+
+	NamespaceFilter         []string          `json:"namespaceFilter,omitempty"`
+	NamespaceSelector       *string           `json:"namespaceSelector,omitempty"`
+	NamespacePattern        []string          `json:"namespacePattern,omitempty"`
+	NamespaceRegex          []string          `json:"namespaceRegex,omitempty"`
+	NamespaceExclude        []string          `json:"namespaceExclude,omitempty"`
+	ServiceAnnotationFilter map[string]string `json:"serviceAnnotationFilter,omitempty"`
+	ServiceLabelFilter      map[string]string `json:"serviceLabelFilter,omitempty"`
+	TypeFilter              []string          `json:"typeFilter,omitempty"`
+
+	// namespaceRegexps are the compiled NamespaceRegex
+	namespaceRegexps []*regexp.Regexp
+}
+
+// KubeconfigSecret references a kubeconfig stored in a Secret of the cluster
+// the syncer runs in
+type KubeconfigSecret struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Key       string `json:"key,omitempty"`
+}
+
+// LoadClusters reads the cluster definitions from a YAML or JSON file
+func LoadClusters(path string) ([]Cluster, error) {
+	var clusters []Cluster
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.UnmarshalStrict(data, &clusters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster file %s: %v", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i, cluster := range clusters {
+		if cluster.Name == "" {
+			return nil, fmt.Errorf("cluster %d in %s has no name", i, path)
+		}
+		if names[cluster.Name] {
+			return nil, fmt.Errorf("cluster %s is defined more than once in %s", cluster.Name, path)
+		}
+		names[cluster.Name] = true
+
+		if cluster.KubeconfigSecret != nil && cluster.Kubeconfig != "" {
+			return nil, fmt.Errorf("cluster %s sets both kubeconfig and kubeconfigSecret", cluster.Name)
+		}
+		if cluster.KubeconfigSecret != nil && (cluster.KubeconfigSecret.Namespace == "" || cluster.KubeconfigSecret.Name == "") {
+			return nil, fmt.Errorf("cluster %s kubeconfigSecret needs a namespace and a name", cluster.Name)
+		}
+		if cluster.NamespaceSelector != nil {
+			if _, err := labels.Parse(*cluster.NamespaceSelector); err != nil {
+				return nil, fmt.Errorf("cluster %s has an invalid namespaceSelector: %v", cluster.Name, err)
+			}
+		}
+		clusters[i].namespaceRegexps, err = compileRegexes(cluster.NamespaceRegex)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s has an invalid namespaceRegex %v", cluster.Name, err)
+		}
+	}
+
+	return clusters, nil
+}
+
+// ForCluster returns a copy of the settings with the cluster name, state
+// ConfigMap and filters overridden by the cluster definition
+func (s Settings) ForCluster(cluster Cluster) Settings {
+	s.KubernetesCluster = cluster.Name
+
+	s.KubernetesConfigMapName = fmt.Sprintf("%s-%s", s.KubernetesConfigMapName, cluster.Name)
+	if cluster.ConfigMapName != "" {
+		s.KubernetesConfigMapName = cluster.ConfigMapName
+	}
+	s.StateFile = suffixPath(s.StateFile, cluster.Name)
+
+	// a cluster pattern or regex replaces the default namespace filter
+	if s.namespaceFilterDefaulted && (len(cluster.NamespacePattern) > 0 || len(cluster.NamespaceRegex) > 0) {
+		s.KubernetesNamespaceFilter = nil
+		s.namespaceFilterDefaulted = false
+	}
+	if cluster.NamespaceFilter != nil {
+		s.KubernetesNamespaceFilter = cluster.NamespaceFilter
+		s.namespaceFilterDefaulted = false
+	}
+	if cluster.NamespaceSelector != nil {
+		s.KubernetesNamespaceSelector = *cluster.NamespaceSelector
+	}
+	if cluster.NamespacePattern != nil {
+		s.KubernetesNamespacePattern = cluster.NamespacePattern
+	}
+	if cluster.NamespaceRegex != nil {
+		s.KubernetesNamespaceRegex = cluster.NamespaceRegex
+		s.KubernetesNamespaceRegexps = cluster.namespaceRegexps
+	}
+	if cluster.NamespaceExclude != nil {
+		s.KubernetesNamespaceExclude = cluster.NamespaceExclude
+	}
+	if cluster.ServiceAnnotationFilter != nil {
+		s.KubernetesServiceAnnotationFilter = []map[string]string{cluster.ServiceAnnotationFilter}
+	}
+	if cluster.ServiceLabelFilter != nil {
+		s.KubernetesServiceLabelFilter = []map[string]string{cluster.ServiceLabelFilter}
+	}
+	if cluster.TypeFilter != nil {
+		s.KubernetesTypeFilter = cluster.TypeFilter
+	}
+
+	return s
+}
+
+// suffixPath inserts a suffix before the extension of a file path
+func suffixPath(path string, suffix string) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(path, ext), suffix, ext)
+}
@@ -0,0 +1,112 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadClusters(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		expectErr bool
+		expected  []string
+	}{
+		{"Kubeconfig and context", "- name: a\n  kubeconfig: /tmp/a\n  context: a\n", false, []string{"a"}},
+		{"Kubeconfig secret", "- name: b\n  kubeconfigSecret:\n    namespace: infra\n    name: b\n", false, []string{"b"}},
+		{"Multiple clusters", "- name: a\n- name: b\n", false, []string{"a", "b"}},
+		{"Missing name", "- context: a\n", true, nil},
+		{"Duplicate name", "- name: a\n- name: a\n", true, nil},
+		{"Secret without name", "- name: a\n  kubeconfigSecret:\n    namespace: infra\n", true, nil},
+		{"Both kubeconfig sources", "- name: a\n  kubeconfig: /tmp/a\n  kubeconfigSecret:\n    namespace: infra\n    name: a\n", true, nil},
+		{"Invalid selector", "- name: a\n  namespaceSelector: \"team in (\"\n", true, nil},
+		{"Invalid regex", "- name: a\n  namespaceRegex: [\"team-(\"]\n", true, nil},
+		{"Unknown field", "- name: a\n  kubeconfg: /tmp/a\n", true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "clusters.yaml")
+			if err := os.WriteFile(path, []byte(tt.input), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			clusters, err := LoadClusters(path)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("LoadClusters(%q) expected error but got none", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadClusters(%q) unexpected error: %v", tt.input, err)
+			}
+
+			var names []string
+			for _, cluster := range clusters {
+				names = append(names, cluster.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("LoadClusters(%q) returned %v, expected %v", tt.input, names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestForCluster(t *testing.T) {
+	base := Settings{
+		KubernetesCluster:         "default",
+		KubernetesConfigMapName:   "k8s-netbox-syncer-config",
+		KubernetesNamespaceFilter: []string{"istio-system"},
+		KubernetesTypeFilter:      []string{"LoadBalancer"},
+	}
+	selector := "team=payments"
+
+	result := base.ForCluster(Cluster{
+		Name:               "prod-a",
+		NamespaceSelector:  &selector,
+		NamespaceFilter:    []string{},
+		ServiceLabelFilter: map[string]string{"expose": "public"},
+	})
+
+	if result.KubernetesCluster != "prod-a" {
+		t.Errorf("KubernetesCluster = %q, expected %q", result.KubernetesCluster, "prod-a")
+	}
+	if result.KubernetesConfigMapName != "k8s-netbox-syncer-config-prod-a" {
+		t.Errorf("KubernetesConfigMapName = %q, expected %q", result.KubernetesConfigMapName, "k8s-netbox-syncer-config-prod-a")
+	}
+	if result.KubernetesNamespaceSelector != selector {
+		t.Errorf("KubernetesNamespaceSelector = %q, expected %q", result.KubernetesNamespaceSelector, selector)
+	}
+	if len(result.KubernetesNamespaceFilter) != 0 {
+		t.Errorf("KubernetesNamespaceFilter = %v, expected it to be cleared", result.KubernetesNamespaceFilter)
+	}
+	if !reflect.DeepEqual(result.KubernetesTypeFilter, base.KubernetesTypeFilter) {
+		t.Errorf("KubernetesTypeFilter = %v, expected it to be inherited", result.KubernetesTypeFilter)
+	}
+	if !reflect.DeepEqual(result.KubernetesServiceLabelFilter, []map[string]string{{"expose": "public"}}) {
+		t.Errorf("KubernetesServiceLabelFilter = %v, expected the cluster filter", result.KubernetesServiceLabelFilter)
+	}
+	if base.KubernetesCluster != "default" {
+		t.Errorf("ForCluster modified the base settings")
+	}
+}
+
+func TestForClusterDefaultNamespaceFilter(t *testing.T) {
+	base := Settings{
+		KubernetesNamespaceFilter: []string{DefaultNamespaceFilter},
+		namespaceFilterDefaulted:  true,
+	}
+
+	inherited := base.ForCluster(Cluster{Name: "prod-a"})
+	if !reflect.DeepEqual(inherited.KubernetesNamespaceFilter, []string{DefaultNamespaceFilter}) {
+		t.Errorf("KubernetesNamespaceFilter = %v, expected the inherited default", inherited.KubernetesNamespaceFilter)
+	}
+
+	replaced := base.ForCluster(Cluster{Name: "prod-b", NamespacePattern: []string{"team-*"}})
+	if replaced.KubernetesNamespaceFilter != nil {
+		t.Errorf("KubernetesNamespaceFilter = %v, expected the default to be replaced by the cluster pattern", replaced.KubernetesNamespaceFilter)
+	}
+}
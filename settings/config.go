@@ -0,0 +1,252 @@
+package settings
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigSchema is the JSON Schema the configuration file is validated against
+//
+//go:embed config.schema.json
+var ConfigSchema []byte
+
+const configSchemaURL = "https://github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings/config.schema.json"
+
+// Config is the configuration file, a nested alternative to the environment
+// variables. Every field is tagged with the environment variable it stands
+// for, unset fields keep the value of the environment or its default.
+type Config struct {
+	Netbox        NetboxConfig        `json:"netbox"`
+	Kubernetes    KubernetesConfig    `json:"kubernetes"`
+	State         StateConfig         `json:"state"`
+	DeletionGuard DeletionGuardConfig `json:"deletionGuard"`
+	Timeouts      TimeoutsConfig      `json:"timeouts"`
+	Metrics       MetricsConfig       `json:"metrics"`
+	Logging       LoggingConfig       `json:"logging"`
+	Report        ReportConfig        `json:"report"`
+	Audit         AuditConfig         `json:"audit"`
+}
+
+type NetboxConfig struct {
+	URL                 *string           `json:"url" env:"NETBOX_URL"`
+	APIToken            *string           `json:"apiToken" env:"NETBOX_API_TOKEN"`
+	CustomFields        map[string]string `json:"customFields" env:"NETBOX_CUSTOM_FIELD"`
+	DeletionPolicy      *string           `json:"deletionPolicy" env:"NETBOX_DELETION_POLICY"`
+	DeletionGracePeriod *Duration         `json:"deletionGracePeriod" env:"NETBOX_DELETION_GRACE_PERIOD"`
+	OwnershipTag        *string           `json:"ownershipTag" env:"NETBOX_OWNERSHIP_TAG"`
+	RemovedTag          *string           `json:"removedTag" env:"NETBOX_REMOVED_TAG"`
+	RemovedCustomField  *string           `json:"removedCustomField" env:"NETBOX_REMOVED_CUSTOM_FIELD"`
+	Protection          ProtectionConfig  `json:"protection"`
+	Concurrency         *int              `json:"concurrency" env:"NETBOX_CONCURRENCY"`
+	BulkSize            *int              `json:"bulkSize" env:"NETBOX_BULK_SIZE"`
+	Retry               NetboxRetryConfig `json:"retry"`
+}
+
+type ProtectionConfig struct {
+	Tag         *string `json:"tag" env:"NETBOX_PROTECTION_TAG"`
+	CustomField *string `json:"customField" env:"NETBOX_PROTECTION_CUSTOM_FIELD"`
+}
+
+type NetboxRetryConfig struct {
+	Attempts *int      `json:"attempts" env:"NETBOX_RETRY_ATTEMPTS"`
+	Backoff  *Duration `json:"backoff" env:"NETBOX_RETRY_BACKOFF"`
+}
+
+type KubernetesConfig struct {
+	Cluster                 *string           `json:"cluster" env:"KUBERNETES_CLUSTER"`
+	ClustersFile            *string           `json:"clustersFile" env:"KUBERNETES_CLUSTERS_FILE"`
+	SyncPolicies            *bool             `json:"syncPolicies" env:"KUBERNETES_SYNC_POLICIES"`
+	ServiceWriteback        *bool             `json:"serviceWriteback" env:"KUBERNETES_SERVICE_WRITEBACK"`
+	TypeFilter              []string          `json:"typeFilter" env:"KUBERNETES_TYPE_FILTER"`
+	ServiceAnnotationFilter map[string]string `json:"serviceAnnotationFilter" env:"KUBERNETES_SERVICE_ANNOTATION_FILTER"`
+	ServiceLabelFilter      map[string]string `json:"serviceLabelFilter" env:"KUBERNETES_SERVICE_LABEL_FILTER"`
+	Namespaces              NamespacesConfig  `json:"namespaces"`
+}
+
+type NamespacesConfig struct {
+	Filter   []string `json:"filter" env:"KUBERNETES_NAMESPACE_FILTER"`
+	Selector *string  `json:"selector" env:"KUBERNETES_NAMESPACE_SELECTOR"`
+	Pattern  []string `json:"pattern" env:"KUBERNETES_NAMESPACE_PATTERN"`
+	Regex    []string `json:"regex" env:"KUBERNETES_NAMESPACE_REGEX"`
+	Exclude  []string `json:"exclude" env:"KUBERNETES_NAMESPACE_EXCLUDE"`
+}
+
+type StateConfig struct {
+	Backend            *string `json:"backend" env:"STATE_BACKEND"`
+	File               *string `json:"file" env:"STATE_FILE"`
+	ConfigMapName      *string `json:"configMapName" env:"KUBERNETES_CONFIGMAP_NAME"`
+	ConfigMapNamespace *string `json:"configMapNamespace" env:"KUBERNETES_CONFIGMAP_NAMESPACE"`
+	Compression        *bool   `json:"compression" env:"KUBERNETES_STATE_COMPRESSION"`
+	ShardSize          *int    `json:"shardSize" env:"KUBERNETES_STATE_SHARD_SIZE"`
+	ConflictPolicy     *string `json:"conflictPolicy" env:"KUBERNETES_STATE_CONFLICT_POLICY"`
+}
+
+type DeletionGuardConfig struct {
+	MaxCount          *int  `json:"maxCount" env:"DELETION_GUARD_MAX_COUNT"`
+	MaxPercent        *int  `json:"maxPercent" env:"DELETION_GUARD_MAX_PERCENT"`
+	MinInventory      *int  `json:"minInventory" env:"DELETION_GUARD_MIN_INVENTORY"`
+	AllowMassDeletion *bool `json:"allowMassDeletion" env:"ALLOW_MASS_DELETION"`
+}
+
+type TimeoutsConfig struct {
+	API *Duration `json:"api" env:"API_TIMEOUT"`
+	Run *Duration `json:"run" env:"RUN_TIMEOUT"`
+}
+
+// Duration is a time.Duration written as a Go duration string, e.g. 30s
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+type MetricsConfig struct {
+	Address        *string `json:"address" env:"METRICS_ADDRESS"`
+	PushgatewayURL *string `json:"pushgatewayURL" env:"METRICS_PUSHGATEWAY_URL"`
+	PushgatewayJob *string `json:"pushgatewayJob" env:"METRICS_PUSHGATEWAY_JOB"`
+}
+
+type LoggingConfig struct {
+	Level  *string `json:"level" env:"LOG_LEVEL"`
+	Format *string `json:"format" env:"LOG_FORMAT"`
+}
+
+type ReportConfig struct {
+	Output        *string `json:"output" env:"REPORT_OUTPUT"`
+	File          *string `json:"file" env:"REPORT_FILE"`
+	ConfigMapName *string `json:"configMapName" env:"REPORT_CONFIGMAP_NAME"`
+}
+
+type AuditConfig struct {
+	Journal             *string `json:"journal" env:"AUDIT_JOURNAL"`
+	File                *string `json:"file" env:"AUDIT_FILE"`
+	ConfigMapName       *string `json:"configMapName" env:"AUDIT_CONFIGMAP_NAME"`
+	ConfigMapMaxEntries *int    `json:"configMapMaxEntries" env:"AUDIT_CONFIGMAP_MAX_ENTRIES"`
+}
+
+var compileConfigSchema = sync.OnceValues(func() (*jsonschema.Schema, error) {
+	schema, err := jsonschema.UnmarshalJSON(bytes.NewReader(ConfigSchema))
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	err = compiler.AddResource(configSchemaURL, schema)
+	if err != nil {
+		return nil, err
+	}
+	return compiler.Compile(configSchemaURL)
+})
+
+// LoadConfig reads a YAML or JSON configuration file and validates it
+// against ConfigSchema
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	schema, err := compileConfigSchema()
+	if err != nil {
+		return config, fmt.Errorf("invalid config schema: %v", err)
+	}
+
+	document, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	err = schema.Validate(document)
+	if err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// apply sets the settings from the configuration file, except the ones whose
+// environment variable is set to a non-empty value
+func (c Config) apply(s *Settings) {
+	fields := make(map[string]reflect.Value)
+	settings := reflect.ValueOf(s).Elem()
+	for i := 0; i < settings.NumField(); i++ {
+		if env := settings.Type().Field(i).Tag.Get("envconfig"); env != "" {
+			fields[env] = settings.Field(i)
+		}
+	}
+
+	applyConfig(reflect.ValueOf(c), fields)
+}
+
+func applyConfig(config reflect.Value, fields map[string]reflect.Value) {
+	for i := 0; i < config.NumField(); i++ {
+		value := config.Field(i)
+		env := config.Type().Field(i).Tag.Get("env")
+		if env == "" {
+			if value.Kind() == reflect.Struct {
+				applyConfig(value, fields)
+			}
+			continue
+		}
+		if value.IsNil() || os.Getenv(env) != "" {
+			continue
+		}
+
+		switch value.Kind() {
+		case reflect.Pointer:
+			fields[env].Set(value.Elem().Convert(fields[env].Type()))
+		case reflect.Slice:
+			fields[env].Set(value)
+		case reflect.Map:
+			fields[env].Set(reflect.ValueOf(splitMap(value.Interface().(map[string]string))))
+		}
+	}
+}
+
+// splitMap converts a map to the one entry maps envconfig parses key:value
+// lists into, sorted by key
+func splitMap(m map[string]string) []map[string]string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	maps := []map[string]string{}
+	for _, key := range keys {
+		maps = append(maps, map[string]string{key: m[key]})
+	}
+	return maps
+}
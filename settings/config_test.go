@@ -0,0 +1,222 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		expectedErr string
+	}{
+		{
+			name: "Valid",
+			content: `
+netbox:
+  url: https://netbox.example.com/api/
+  customFields:
+    purpose: "load-balancer, public"
+    owner_team: "team:platform"
+kubernetes:
+  cluster: prod-a
+  namespaces:
+    filter: [team-a, team-b]
+state:
+  backend: secret
+  shardSize: 1024
+timeouts:
+  api: 10s
+  run: 1h30m
+`,
+		},
+		{
+			name:        "Unknown key",
+			content:     "netbox:\n  uri: https://netbox.example.com/api/\n",
+			expectedErr: "/netbox",
+		},
+		{
+			name:        "Invalid URL",
+			content:     "netbox:\n  url: netbox.example.com\n",
+			expectedErr: "/netbox/url",
+		},
+		{
+			name:        "Invalid custom field key",
+			content:     "netbox:\n  customFields:\n    Owner-Team: platform\n",
+			expectedErr: "/netbox/customFields",
+		},
+		{
+			name:        "Invalid cluster name",
+			content:     "kubernetes:\n  cluster: prod a\n",
+			expectedErr: "/kubernetes/cluster",
+		},
+		{
+			name:        "Invalid enum",
+			content:     "state:\n  backend: etcd\n",
+			expectedErr: "/state/backend",
+		},
+		{
+			name:        "Invalid duration",
+			content:     "timeouts:\n  api: 10 seconds\n",
+			expectedErr: "/timeouts/api",
+		},
+		{
+			name:        "Wrong type",
+			content:     "state:\n  shardSize: large\n",
+			expectedErr: "/state/shardSize",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadConfig(path)
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Errorf("LoadConfig unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("LoadConfig expected error but got none")
+			}
+			if !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("LoadConfig error %q does not point at %s", err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestNewSettingsWithConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+netbox:
+  url: https://netbox.file/api/
+  apiToken: file-token
+  customFields:
+    purpose: "load-balancer, public"
+    environment: production
+kubernetes:
+  cluster: file-cluster
+  namespaces:
+    filter: [team-a]
+timeouts:
+  run: 15m
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("NETBOX_URL", "")
+	t.Setenv("NETBOX_API_TOKEN", "")
+	t.Setenv("KUBERNETES_CLUSTER", "env-cluster")
+
+	settings, err := NewSettings()
+	if err != nil {
+		t.Fatalf("NewSettings unexpected error: %v", err)
+	}
+
+	if settings.NetboxURL != "https://netbox.file/api/" {
+		t.Errorf("NetboxURL = %q, expected the file value", settings.NetboxURL)
+	}
+	if settings.KubernetesCluster != "env-cluster" {
+		t.Errorf("KubernetesCluster = %q, expected the environment value", settings.KubernetesCluster)
+	}
+	expectedFields := []map[string]string{{"environment": "production"}, {"purpose": "load-balancer, public"}}
+	if !reflect.DeepEqual(settings.NetboxCustomField, expectedFields) {
+		t.Errorf("NetboxCustomField = %v, expected %v", settings.NetboxCustomField, expectedFields)
+	}
+	if !reflect.DeepEqual(settings.KubernetesNamespaceFilter, []string{"team-a"}) {
+		t.Errorf("KubernetesNamespaceFilter = %v, expected the file value", settings.KubernetesNamespaceFilter)
+	}
+	if settings.RunTimeout != 15*time.Minute {
+		t.Errorf("RunTimeout = %s, expected the file value", settings.RunTimeout)
+	}
+	if settings.KubernetesConfigMapName != "k8s-netbox-syncer-config" {
+		t.Errorf("KubernetesConfigMapName = %q, expected the default", settings.KubernetesConfigMapName)
+	}
+}
+
+func TestNewSettingsValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"Missing URL", map[string]string{"NETBOX_URL": ""}},
+		{"Relative URL", map[string]string{"NETBOX_URL": "netbox.example.com/api"}},
+		{"Cluster with spaces", map[string]string{"KUBERNETES_CLUSTER": "prod a"}},
+		{"Custom field key", map[string]string{"NETBOX_CUSTOM_FIELD": "Owner-Team:platform"}},
+		{"Zero API timeout", map[string]string{"API_TIMEOUT": "0"}},
+		{"Unknown deletion policy", map[string]string{"NETBOX_DELETION_POLICY": "archive"}},
+		{"Tag policy without removed tag", map[string]string{"NETBOX_DELETION_POLICY": "tag", "NETBOX_REMOVED_TAG": ""}},
+		{"Removed custom field key", map[string]string{"NETBOX_REMOVED_CUSTOM_FIELD": "Removed-At"}},
+		{"Protection custom field key", map[string]string{"NETBOX_PROTECTION_CUSTOM_FIELD": "Protected!"}},
+		{"Unknown audit journal", map[string]string{"AUDIT_JOURNAL": "syslog"}},
+		{"No audit ConfigMap entries", map[string]string{"AUDIT_CONFIGMAP_MAX_ENTRIES": "0"}},
+		{"Invalid namespace regex", map[string]string{"KUBERNETES_NAMESPACE_REGEX": "team-(["}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NETBOX_URL", "https://netbox.example.com/api/")
+			t.Setenv("NETBOX_API_TOKEN", "token")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			if _, err := NewSettings(); err == nil {
+				t.Error("NewSettings expected error but got none")
+			}
+		})
+	}
+}
+
+func TestNewSettingsNamespaceFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected []string
+		regexps  int
+	}{
+		{"Default", nil, []string{DefaultNamespaceFilter}, 0},
+		{"Names", map[string]string{"KUBERNETES_NAMESPACE_FILTER": "team-a,team-b"}, []string{"team-a", "team-b"}, 0},
+		{"Pattern replaces the default", map[string]string{"KUBERNETES_NAMESPACE_PATTERN": "team-*"}, nil, 0},
+		{"Regex replaces the default", map[string]string{"KUBERNETES_NAMESPACE_REGEX": "^team-[0-9]+$"}, nil, 1},
+		{"Every namespace", map[string]string{"KUBERNETES_NAMESPACE_FILTER": ""}, []string{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NETBOX_URL", "https://netbox.example.com/api/")
+			t.Setenv("NETBOX_API_TOKEN", "token")
+			for _, key := range []string{"KUBERNETES_NAMESPACE_FILTER", "KUBERNETES_NAMESPACE_PATTERN", "KUBERNETES_NAMESPACE_REGEX"} {
+				t.Setenv(key, "")
+				os.Unsetenv(key)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			settings, err := NewSettings()
+			if err != nil {
+				t.Fatalf("NewSettings unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(settings.KubernetesNamespaceFilter, tt.expected) {
+				t.Errorf("KubernetesNamespaceFilter = %#v, expected %#v", settings.KubernetesNamespaceFilter, tt.expected)
+			}
+			if len(settings.KubernetesNamespaceRegexps) != tt.regexps {
+				t.Errorf("got %d compiled namespace regexes, expected %d", len(settings.KubernetesNamespaceRegexps), tt.regexps)
+			}
+		})
+	}
+}
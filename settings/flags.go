@@ -0,0 +1,40 @@
+package settings
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// BindFlags adds a flag for every setting to the flag set, named after its
+// environment variable, e.g. --netbox-url for NETBOX_URL. A flag overrides
+// the environment variable, so NewSettings must be called after parsing.
+func BindFlags(flags *flag.FlagSet) {
+	bind(flags, "kubeconfig", "KUBECONFIG", false)
+
+	fields := reflect.TypeOf(Settings{})
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		env := field.Tag.Get("envconfig")
+		if env == "" {
+			continue
+		}
+
+		name := strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+		bind(flags, name, env, field.Type.Kind() == reflect.Bool)
+	}
+}
+
+func bind(flags *flag.FlagSet, name string, env string, isBool bool) {
+	usage := "overrides " + env
+	setenv := func(value string) error {
+		return os.Setenv(env, value)
+	}
+
+	if isBool {
+		flags.BoolFunc(name, usage, setenv)
+		return
+	}
+	flags.Func(name, usage, setenv)
+}
@@ -0,0 +1,45 @@
+package settings
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestBindFlags(t *testing.T) {
+	t.Setenv("NETBOX_URL", "http://netbox.env/api/")
+	t.Setenv("NETBOX_API_TOKEN", "token")
+	t.Setenv("KUBERNETES_CLUSTER", "env-cluster")
+	t.Setenv("KUBERNETES_SERVICE_WRITEBACK", "false")
+	t.Setenv("KUBERNETES_NAMESPACE_FILTER", "istio-system")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	BindFlags(flags)
+
+	err := flags.Parse([]string{
+		"--netbox-url", "http://netbox.flag/api/",
+		"--kubernetes-service-writeback",
+		"--kubernetes-namespace-filter", "team-a,team-b",
+	})
+	if err != nil {
+		t.Fatalf("Parse unexpected error: %v", err)
+	}
+
+	settings, err := NewSettings()
+	if err != nil {
+		t.Fatalf("NewSettings unexpected error: %v", err)
+	}
+
+	if settings.NetboxURL != "http://netbox.flag/api/" {
+		t.Errorf("NetboxURL = %q, expected the flag value", settings.NetboxURL)
+	}
+	if settings.KubernetesCluster != "env-cluster" {
+		t.Errorf("KubernetesCluster = %q, expected the environment value", settings.KubernetesCluster)
+	}
+	if !settings.KubernetesServiceWriteback {
+		t.Errorf("KubernetesServiceWriteback = false, expected the flag value")
+	}
+	if !reflect.DeepEqual(settings.KubernetesNamespaceFilter, []string{"team-a", "team-b"}) {
+		t.Errorf("KubernetesNamespaceFilter = %v, expected the flag value", settings.KubernetesNamespaceFilter)
+	}
+}
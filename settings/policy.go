@@ -0,0 +1,102 @@
+package settings
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ForPolicy returns a copy of the settings configured by a NetboxSyncPolicy.
+// The policy replaces every filter, unset source fields select everything.
+func (s Settings) ForPolicy(policy v1alpha1.NetboxSyncPolicy) (Settings, error) {
+	if policy.Spec.Cluster != "" {
+		s.KubernetesCluster = policy.Spec.Cluster
+	}
+	s.KubernetesConfigMapName = fmt.Sprintf("%s-%s", s.KubernetesConfigMapName, policy.Name)
+	s.StateFile = suffixPath(s.StateFile, policy.Name)
+
+	source := policy.Spec.Source
+	s.KubernetesNamespaceSelector = ""
+	if source.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(source.NamespaceSelector)
+		if err != nil {
+			return s, fmt.Errorf("invalid namespaceSelector: %v", err)
+		}
+		s.KubernetesNamespaceSelector = selector.String()
+	}
+
+	regexps, err := compileRegexes(source.NamespaceRegexes)
+	if err != nil {
+		return s, fmt.Errorf("invalid namespaceRegexes %v", err)
+	}
+
+	s.KubernetesNamespaceFilter = source.Namespaces
+	s.namespaceFilterDefaulted = false
+	s.KubernetesNamespacePattern = source.NamespacePatterns
+	s.KubernetesNamespaceRegex = source.NamespaceRegexes
+	s.KubernetesNamespaceRegexps = regexps
+	s.KubernetesNamespaceExclude = source.ExcludeNamespaces
+	s.KubernetesTypeFilter = source.ServiceTypes
+
+	s.KubernetesServiceLabelFilter = nil
+	if len(source.ServiceLabels) > 0 {
+		s.KubernetesServiceLabelFilter = []map[string]string{source.ServiceLabels}
+	}
+
+	s.KubernetesServiceAnnotationFilter = nil
+	if len(source.ServiceAnnotations) > 0 {
+		s.KubernetesServiceAnnotationFilter = []map[string]string{source.ServiceAnnotations}
+	}
+
+	if policy.Spec.Netbox.CustomFields != nil {
+		s.NetboxCustomField = []map[string]string{policy.Spec.Netbox.CustomFields}
+	}
+
+	switch policy.Spec.DeletionPolicy {
+	case "", v1alpha1.DeletionPolicyDestroy:
+		s.NetboxDeletionPolicy = DeletionPolicyDestroy
+	case v1alpha1.DeletionPolicyRetain:
+		s.NetboxDeletionPolicy = DeletionPolicyRetain
+	case v1alpha1.DeletionPolicyDeprecate:
+		s.NetboxDeletionPolicy = DeletionPolicyDeprecate
+	case v1alpha1.DeletionPolicyTag:
+		if s.NetboxRemovedTag == "" {
+			return s, fmt.Errorf("deletionPolicy Tag requires NETBOX_REMOVED_TAG")
+		}
+		s.NetboxDeletionPolicy = DeletionPolicyTag
+	default:
+		return s, fmt.Errorf("invalid deletionPolicy %q", policy.Spec.DeletionPolicy)
+	}
+
+	return s, nil
+}
+
+// PolicyConflicts returns an error for every NetboxSyncPolicy syncing the same
+// cluster as another one, keyed by policy name. Such policies would find the
+// prefixes of each other in Netbox and remove them as orphans.
+func PolicyConflicts(s Settings, policies []v1alpha1.NetboxSyncPolicy) map[string]error {
+	byCluster := make(map[string][]string)
+	for _, policy := range policies {
+		cluster := s.KubernetesCluster
+		if policy.Spec.Cluster != "" {
+			cluster = policy.Spec.Cluster
+		}
+		byCluster[cluster] = append(byCluster[cluster], policy.Name)
+	}
+
+	conflicts := make(map[string]error)
+	for cluster, names := range byCluster {
+		if len(names) < 2 {
+			continue
+		}
+		for _, name := range names {
+			others := slices.DeleteFunc(slices.Clone(names), func(other string) bool { return other == name })
+			conflicts[name] = fmt.Errorf("cluster %q is also synced by %s, set a distinct spec.cluster", cluster, strings.Join(others, ", "))
+		}
+	}
+
+	return conflicts
+}
@@ -0,0 +1,200 @@
+package settings
+
+import (
+	"reflect"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestForPolicy(t *testing.T) {
+	base := Settings{
+		KubernetesCluster:                 "default",
+		KubernetesConfigMapName:           "state",
+		StateFile:                         "/data/prefixes.json",
+		KubernetesNamespaceFilter:         []string{DefaultNamespaceFilter},
+		namespaceFilterDefaulted:          true,
+		KubernetesNamespaceSelector:       "team=a",
+		KubernetesNamespaceExclude:        []string{"kube-system"},
+		KubernetesTypeFilter:              []string{"LoadBalancer"},
+		KubernetesServiceLabelFilter:      []map[string]string{{"app": "web"}},
+		KubernetesServiceAnnotationFilter: []map[string]string{{"sync": "yes"}},
+		NetboxCustomField:                 []map[string]string{{"owner": "platform"}},
+		NetboxDeletionPolicy:              DeletionPolicyDeprecate,
+	}
+
+	// an empty policy selects everything of its own state
+	policyDefaults := func() Settings {
+		s := base
+		s.KubernetesConfigMapName = "state-web"
+		s.StateFile = "/data/prefixes-web.json"
+		s.KubernetesNamespaceFilter = nil
+		s.namespaceFilterDefaulted = false
+		s.KubernetesNamespaceSelector = ""
+		s.KubernetesNamespaceExclude = nil
+		s.KubernetesTypeFilter = nil
+		s.KubernetesServiceLabelFilter = nil
+		s.KubernetesServiceAnnotationFilter = nil
+		s.NetboxDeletionPolicy = DeletionPolicyDestroy
+		return s
+	}
+
+	tests := []struct {
+		name      string
+		spec      v1alpha1.NetboxSyncPolicySpec
+		expected  func() Settings
+		expectErr bool
+	}{
+		{
+			name:     "Empty policy",
+			expected: policyDefaults,
+		},
+		{
+			name: "Cluster",
+			spec: v1alpha1.NetboxSyncPolicySpec{Cluster: "prod-a"},
+			expected: func() Settings {
+				s := policyDefaults()
+				s.KubernetesCluster = "prod-a"
+				return s
+			},
+		},
+		{
+			name: "Namespaces",
+			spec: v1alpha1.NetboxSyncPolicySpec{Source: v1alpha1.SourceSpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}},
+				Namespaces:        []string{"web"},
+				NamespacePatterns: []string{"team-*"},
+				NamespaceRegexes:  []string{"^ops-"},
+				ExcludeNamespaces: []string{"team-test"},
+			}},
+			expected: func() Settings {
+				s := policyDefaults()
+				s.KubernetesNamespaceSelector = "team=b"
+				s.KubernetesNamespaceFilter = []string{"web"}
+				s.KubernetesNamespacePattern = []string{"team-*"}
+				s.KubernetesNamespaceRegex = []string{"^ops-"}
+				s.KubernetesNamespaceRegexps = []*regexp.Regexp{regexp.MustCompile("^ops-")}
+				s.KubernetesNamespaceExclude = []string{"team-test"}
+				return s
+			},
+		},
+		{
+			name: "Services",
+			spec: v1alpha1.NetboxSyncPolicySpec{Source: v1alpha1.SourceSpec{
+				ServiceTypes:       []string{"NodePort"},
+				ServiceLabels:      map[string]string{"tier": "edge"},
+				ServiceAnnotations: map[string]string{"expose": "true"},
+			}},
+			expected: func() Settings {
+				s := policyDefaults()
+				s.KubernetesTypeFilter = []string{"NodePort"}
+				s.KubernetesServiceLabelFilter = []map[string]string{{"tier": "edge"}}
+				s.KubernetesServiceAnnotationFilter = []map[string]string{{"expose": "true"}}
+				return s
+			},
+		},
+		{
+			name: "Custom fields",
+			spec: v1alpha1.NetboxSyncPolicySpec{Netbox: v1alpha1.NetboxSpec{CustomFields: map[string]string{"owner": "web"}}},
+			expected: func() Settings {
+				s := policyDefaults()
+				s.NetboxCustomField = []map[string]string{{"owner": "web"}}
+				return s
+			},
+		},
+		{
+			name: "Retain deletion policy",
+			spec: v1alpha1.NetboxSyncPolicySpec{DeletionPolicy: v1alpha1.DeletionPolicyRetain},
+			expected: func() Settings {
+				s := policyDefaults()
+				s.NetboxDeletionPolicy = DeletionPolicyRetain
+				return s
+			},
+		},
+		{
+			name: "Deprecate deletion policy",
+			spec: v1alpha1.NetboxSyncPolicySpec{DeletionPolicy: v1alpha1.DeletionPolicyDeprecate},
+			expected: func() Settings {
+				s := policyDefaults()
+				s.NetboxDeletionPolicy = DeletionPolicyDeprecate
+				return s
+			},
+		},
+		{
+			name:      "Tag deletion policy without removed tag",
+			spec:      v1alpha1.NetboxSyncPolicySpec{DeletionPolicy: v1alpha1.DeletionPolicyTag},
+			expectErr: true,
+		},
+		{
+			name:      "Unknown deletion policy",
+			spec:      v1alpha1.NetboxSyncPolicySpec{DeletionPolicy: "Archive"},
+			expectErr: true,
+		},
+		{
+			name: "Invalid namespace selector",
+			spec: v1alpha1.NetboxSyncPolicySpec{Source: v1alpha1.SourceSpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Near"}}},
+			}},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid namespace regex",
+			spec:      v1alpha1.NetboxSyncPolicySpec{Source: v1alpha1.SourceSpec{NamespaceRegexes: []string{"team-("}}},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := v1alpha1.NetboxSyncPolicy{ObjectMeta: metav1.ObjectMeta{Name: "web"}, Spec: tt.spec}
+
+			result, err := base.ForPolicy(policy)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("ForPolicy expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ForPolicy unexpected error: %v", err)
+			}
+			if expected := tt.expected(); !reflect.DeepEqual(result, expected) {
+				t.Errorf("ForPolicy = %+v, expected %+v", result, expected)
+			}
+		})
+	}
+}
+
+func TestPolicyConflicts(t *testing.T) {
+	policy := func(name string, cluster string) v1alpha1.NetboxSyncPolicy {
+		return v1alpha1.NetboxSyncPolicy{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: v1alpha1.NetboxSyncPolicySpec{Cluster: cluster}}
+	}
+
+	tests := []struct {
+		name     string
+		policies []v1alpha1.NetboxSyncPolicy
+		expected []string
+	}{
+		{"Distinct clusters", []v1alpha1.NetboxSyncPolicy{policy("web", "prod-web"), policy("db", "prod-db")}, nil},
+		{"Default cluster", []v1alpha1.NetboxSyncPolicy{policy("web", ""), policy("db", "")}, []string{"db", "web"}},
+		{"Default cluster set explicitly", []v1alpha1.NetboxSyncPolicy{policy("web", ""), policy("db", "default"), policy("edge", "prod-edge")}, []string{"db", "web"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conflicts := PolicyConflicts(Settings{KubernetesCluster: "default"}, tt.policies)
+
+			var names []string
+			for name := range conflicts {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("PolicyConflicts = %v, expected conflicts for %v", conflicts, tt.expected)
+			}
+		})
+	}
+}
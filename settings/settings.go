@@ -1,22 +1,147 @@
 package settings
 
 import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type Settings struct {
-	NetboxAPIToken                    string              `envconfig:"NETBOX_API_TOKEN" required:"true"`
-	NetboxURL                         string              `envconfig:"NETBOX_URL" required:"true"`
+	ConfigFile                        string              `envconfig:"CONFIG_FILE" default:""`
+	NetboxAPIToken                    string              `envconfig:"NETBOX_API_TOKEN" default:""`
+	NetboxURL                         string              `envconfig:"NETBOX_URL" default:""`
 	NetboxCustomField                 []map[string]string `envconfig:"NETBOX_CUSTOM_FIELD" default:""`
+	NetboxDeletionPolicy              string              `envconfig:"NETBOX_DELETION_POLICY" default:"destroy"`
+	NetboxDeletionGracePeriod         time.Duration       `envconfig:"NETBOX_DELETION_GRACE_PERIOD" default:"0"`
+	NetboxOwnershipTag                string              `envconfig:"NETBOX_OWNERSHIP_TAG" default:""`
+	NetboxRemovedTag                  string              `envconfig:"NETBOX_REMOVED_TAG" default:"k8s-removed"`
+	NetboxRemovedCustomField          string              `envconfig:"NETBOX_REMOVED_CUSTOM_FIELD" default:""`
+	NetboxProtectionTag               string              `envconfig:"NETBOX_PROTECTION_TAG" default:"k8s-protected"`
+	NetboxProtectionCustomField       string              `envconfig:"NETBOX_PROTECTION_CUSTOM_FIELD" default:""`
+	NetboxConcurrency                 int                 `envconfig:"NETBOX_CONCURRENCY" default:"4"`
+	NetboxBulkSize                    int                 `envconfig:"NETBOX_BULK_SIZE" default:"0"`
+	NetboxRetryAttempts               int                 `envconfig:"NETBOX_RETRY_ATTEMPTS" default:"5"`
+	NetboxRetryBackoff                time.Duration       `envconfig:"NETBOX_RETRY_BACKOFF" default:"1s"`
+	DeletionGuardMaxCount             int                 `envconfig:"DELETION_GUARD_MAX_COUNT" default:"0"`
+	DeletionGuardMaxPercent           int                 `envconfig:"DELETION_GUARD_MAX_PERCENT" default:"50"`
+	DeletionGuardMinInventory         int                 `envconfig:"DELETION_GUARD_MIN_INVENTORY" default:"10"`
+	AllowMassDeletion                 bool                `envconfig:"ALLOW_MASS_DELETION" default:"false"`
 	KubernetesCluster                 string              `envconfig:"KUBERNETES_CLUSTER" default:"default"`
+	KubernetesClustersFile            string              `envconfig:"KUBERNETES_CLUSTERS_FILE" default:""`
 	KubernetesConfigMapName           string              `envconfig:"KUBERNETES_CONFIGMAP_NAME" default:"k8s-netbox-syncer-config"`
 	KubernetesConfigMapNamepace       string              `envconfig:"KUBERNETES_CONFIGMAP_NAMESPACE" default:"default"`
+	KubernetesStateCompression        bool                `envconfig:"KUBERNETES_STATE_COMPRESSION" default:"false"`
+	KubernetesStateShardSize          int                 `envconfig:"KUBERNETES_STATE_SHARD_SIZE" default:"524288"`
+	KubernetesStateConflictPolicy     string              `envconfig:"KUBERNETES_STATE_CONFLICT_POLICY" default:"merge"`
+	StateBackend                      string              `envconfig:"STATE_BACKEND" default:"configmap"`
+	StateFile                         string              `envconfig:"STATE_FILE" default:"prefixes.json"`
 	KubernetesServiceAnnotationFilter []map[string]string `envconfig:"KUBERNETES_SERVICE_ANNOTATION_FILTER" default:""`
 	KubernetesServiceLabelFilter      []map[string]string `envconfig:"KUBERNETES_SERVICE_LABEL_FILTER" default:""`
-	KubernetesNamespaceFilter         []string            `envconfig:"KUBERNETES_NAMESPACE_FILTER" default:"istio-system"`
+	KubernetesNamespaceFilter         []string            `envconfig:"KUBERNETES_NAMESPACE_FILTER" default:""`
+	KubernetesNamespaceSelector       string              `envconfig:"KUBERNETES_NAMESPACE_SELECTOR" default:""`
+	KubernetesNamespacePattern        []string            `envconfig:"KUBERNETES_NAMESPACE_PATTERN" default:""`
+	KubernetesNamespaceRegex          []string            `envconfig:"KUBERNETES_NAMESPACE_REGEX" default:""`
+	KubernetesNamespaceExclude        []string            `envconfig:"KUBERNETES_NAMESPACE_EXCLUDE" default:""`
 	KubernetesTypeFilter              []string            `envconfig:"KUBERNETES_TYPE_FILTER" default:"LoadBalancer"`
+	KubernetesServiceWriteback        bool                `envconfig:"KUBERNETES_SERVICE_WRITEBACK" default:"false"`
+	KubernetesSyncPolicies            bool                `envconfig:"KUBERNETES_SYNC_POLICIES" default:"false"`
+	APITimeout                        time.Duration       `envconfig:"API_TIMEOUT" default:"30s"`
+	RunTimeout                        time.Duration       `envconfig:"RUN_TIMEOUT" default:"0"`
+	LogLevel                          string              `envconfig:"LOG_LEVEL" default:"info"`
+	LogFormat                         string              `envconfig:"LOG_FORMAT" default:"text"`
+	MetricsAddress                    string              `envconfig:"METRICS_ADDRESS" default:""`
+	MetricsPushgatewayURL             string              `envconfig:"METRICS_PUSHGATEWAY_URL" default:""`
+	MetricsPushgatewayJob             string              `envconfig:"METRICS_PUSHGATEWAY_JOB" default:"kubernetes-service-netbox-syncer"`
+	ReportOutput                      string              `envconfig:"REPORT_OUTPUT" default:"none"`
+	ReportFile                        string              `envconfig:"REPORT_FILE" default:"report.json"`
+	ReportConfigMapName               string              `envconfig:"REPORT_CONFIGMAP_NAME" default:"k8s-netbox-syncer-report"`
+	AuditJournal                      string              `envconfig:"AUDIT_JOURNAL" default:"none"`
+	AuditFile                         string              `envconfig:"AUDIT_FILE" default:"audit.jsonl"`
+	AuditConfigMapName                string              `envconfig:"AUDIT_CONFIGMAP_NAME" default:"k8s-netbox-syncer-audit"`
+	AuditConfigMapMaxEntries          int                 `envconfig:"AUDIT_CONFIGMAP_MAX_ENTRIES" default:"1000"`
+
+	// KubernetesNamespaceRegexps are the compiled KubernetesNamespaceRegex
+	KubernetesNamespaceRegexps []*regexp.Regexp `ignored:"true"`
+	// namespaceFilterDefaulted is set when KubernetesNamespaceFilter holds
+	// DefaultNamespaceFilter because no namespace filter was configured
+	namespaceFilterDefaulted bool
+}
+
+// DefaultNamespaceFilter is the namespace synced when neither
+// KUBERNETES_NAMESPACE_FILTER, KUBERNETES_NAMESPACE_PATTERN nor
+// KUBERNETES_NAMESPACE_REGEX is set
+const DefaultNamespaceFilter = "istio-system"
+
+const (
+	// DeletionPolicyDestroy deletes the Netbox objects of removed services
+	DeletionPolicyDestroy = "destroy"
+	// DeletionPolicyRetain stops tracking the Netbox objects of removed services
+	DeletionPolicyRetain = "retain"
+	// DeletionPolicyDeprecate sets the status of the Netbox objects of removed
+	// services to deprecated and keeps tracking them
+	DeletionPolicyDeprecate = "deprecate"
+	// DeletionPolicyTag adds NETBOX_REMOVED_TAG to the Netbox objects of
+	// removed services and keeps tracking them
+	DeletionPolicyTag = "tag"
+
+	// StateConflictPolicyMerge merges concurrent state updates
+	StateConflictPolicyMerge = "merge"
+	// StateConflictPolicyAbort fails the save on concurrent state updates
+	StateConflictPolicyAbort = "abort"
+
+	// StateBackendConfigMap, StateBackendSecret, StateBackendFile and
+	// StateBackendNetbox select where the state is kept
+	StateBackendConfigMap = "configmap"
+	StateBackendSecret    = "secret"
+	StateBackendFile      = "file"
+	StateBackendNetbox    = "netbox"
+
+	// LogFormatText and LogFormatJSON select the format of the log lines
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	// ReportOutputNone, ReportOutputStdout, ReportOutputFile and
+	// ReportOutputConfigMap select where the run report of a sync is written
+	ReportOutputNone      = "none"
+	ReportOutputStdout    = "stdout"
+	ReportOutputFile      = "file"
+	ReportOutputConfigMap = "configmap"
+
+	// AuditJournalNone, AuditJournalFile, AuditJournalConfigMap and
+	// AuditJournalNetbox select where the changes made to Netbox are journaled
+	AuditJournalNone      = "none"
+	AuditJournalFile      = "file"
+	AuditJournalConfigMap = "configmap"
+	AuditJournalNetbox    = "netbox"
+)
+
+var (
+	clusterNameRegex    = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+	customFieldKeyRegex = regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)*$`)
+)
+
+// compileRegexes compiles the regular expressions, the error names the
+// invalid one
+func compileRegexes(exprs []string) ([]*regexp.Regexp, error) {
+	var regexps []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", expr, err)
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
 }
 
+// NewSettings reads the settings from the environment and, when CONFIG_FILE
+// is set, from the configuration file. A non-empty environment variable
+// overrides the file.
 func NewSettings() (Settings, error) {
 	var settings Settings
 
@@ -25,5 +150,177 @@ func NewSettings() (Settings, error) {
 		return settings, err
 	}
 
+	if settings.ConfigFile != "" {
+		config, err := LoadConfig(settings.ConfigFile)
+		if err != nil {
+			return settings, err
+		}
+		config.apply(&settings)
+	}
+
+	if settings.NetboxURL == "" {
+		return settings, fmt.Errorf("NETBOX_URL or netbox.url is required")
+	}
+	if settings.NetboxAPIToken == "" {
+		return settings, fmt.Errorf("NETBOX_API_TOKEN or netbox.apiToken is required")
+	}
+
+	if err := validateURL(settings.NetboxURL); err != nil {
+		return settings, fmt.Errorf("invalid NETBOX_URL %q: %v", settings.NetboxURL, err)
+	}
+
+	if settings.NetboxConcurrency < 1 {
+		return settings, fmt.Errorf("NETBOX_CONCURRENCY must be at least 1")
+	}
+
+	if settings.NetboxBulkSize < 0 {
+		return settings, fmt.Errorf("NETBOX_BULK_SIZE must not be negative")
+	}
+
+	if settings.NetboxRetryAttempts < 1 {
+		return settings, fmt.Errorf("NETBOX_RETRY_ATTEMPTS must be at least 1")
+	}
+	if settings.NetboxRetryBackoff <= 0 {
+		return settings, fmt.Errorf("NETBOX_RETRY_BACKOFF must be positive")
+	}
+
+	if settings.NetboxDeletionGracePeriod < 0 {
+		return settings, fmt.Errorf("NETBOX_DELETION_GRACE_PERIOD must not be negative")
+	}
+
+	if settings.DeletionGuardMaxCount < 0 {
+		return settings, fmt.Errorf("DELETION_GUARD_MAX_COUNT must not be negative")
+	}
+	if settings.DeletionGuardMaxPercent < 0 || settings.DeletionGuardMaxPercent > 100 {
+		return settings, fmt.Errorf("DELETION_GUARD_MAX_PERCENT must be between 0 and 100")
+	}
+	if settings.DeletionGuardMinInventory < 0 {
+		return settings, fmt.Errorf("DELETION_GUARD_MIN_INVENTORY must not be negative")
+	}
+
+	if settings.APITimeout <= 0 {
+		return settings, fmt.Errorf("API_TIMEOUT must be positive")
+	}
+	if settings.RunTimeout < 0 {
+		return settings, fmt.Errorf("RUN_TIMEOUT must not be negative")
+	}
+
+	switch strings.ToLower(settings.LogLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		return settings, fmt.Errorf("invalid LOG_LEVEL %q", settings.LogLevel)
+	}
+
+	switch strings.ToLower(settings.LogFormat) {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return settings, fmt.Errorf("invalid LOG_FORMAT %q", settings.LogFormat)
+	}
+
+	if settings.MetricsPushgatewayURL != "" {
+		if err := validateURL(settings.MetricsPushgatewayURL); err != nil {
+			return settings, fmt.Errorf("invalid METRICS_PUSHGATEWAY_URL %q: %v", settings.MetricsPushgatewayURL, err)
+		}
+	}
+
+	switch settings.ReportOutput {
+	case ReportOutputNone, ReportOutputStdout, ReportOutputFile, ReportOutputConfigMap:
+	default:
+		return settings, fmt.Errorf("invalid REPORT_OUTPUT %q", settings.ReportOutput)
+	}
+
+	switch settings.AuditJournal {
+	case AuditJournalNone, AuditJournalFile, AuditJournalConfigMap, AuditJournalNetbox:
+	default:
+		return settings, fmt.Errorf("invalid AUDIT_JOURNAL %q", settings.AuditJournal)
+	}
+	if settings.AuditConfigMapMaxEntries < 1 {
+		return settings, fmt.Errorf("invalid AUDIT_CONFIGMAP_MAX_ENTRIES %d: must be at least 1", settings.AuditConfigMapMaxEntries)
+	}
+
+	if !clusterNameRegex.MatchString(settings.KubernetesCluster) {
+		return settings, fmt.Errorf("invalid KUBERNETES_CLUSTER %q: must be letters, digits, '.', '_' or '-', starting and ending with a letter or digit", settings.KubernetesCluster)
+	}
+
+	for _, field := range settings.NetboxCustomField {
+		for key := range field {
+			if !customFieldKeyRegex.MatchString(key) {
+				return settings, fmt.Errorf("invalid NETBOX_CUSTOM_FIELD key %q: must be lowercase letters, digits and single underscores", key)
+			}
+		}
+	}
+
+	if _, err := labels.Parse(settings.KubernetesNamespaceSelector); err != nil {
+		return settings, fmt.Errorf("invalid KUBERNETES_NAMESPACE_SELECTOR: %v", err)
+	}
+
+	settings.KubernetesNamespaceRegexps, err = compileRegexes(settings.KubernetesNamespaceRegex)
+	if err != nil {
+		return settings, fmt.Errorf("invalid KUBERNETES_NAMESPACE_REGEX %v", err)
+	}
+
+	// the default only applies when no namespace filter is configured, a
+	// pattern or a regex replaces it
+	if settings.KubernetesNamespaceFilter == nil && len(settings.KubernetesNamespacePattern) == 0 && len(settings.KubernetesNamespaceRegex) == 0 {
+		settings.KubernetesNamespaceFilter = []string{DefaultNamespaceFilter}
+		settings.namespaceFilterDefaulted = true
+	}
+
+	if settings.KubernetesStateShardSize <= 0 {
+		return settings, fmt.Errorf("KUBERNETES_STATE_SHARD_SIZE must be positive")
+	}
+
+	switch settings.KubernetesStateConflictPolicy {
+	case StateConflictPolicyMerge, StateConflictPolicyAbort:
+	default:
+		return settings, fmt.Errorf("invalid KUBERNETES_STATE_CONFLICT_POLICY %q", settings.KubernetesStateConflictPolicy)
+	}
+
+	switch settings.StateBackend {
+	case StateBackendConfigMap, StateBackendSecret, StateBackendFile:
+	case StateBackendNetbox:
+		if settings.NetboxOwnershipTag == "" {
+			return settings, fmt.Errorf("STATE_BACKEND netbox requires NETBOX_OWNERSHIP_TAG")
+		}
+	default:
+		return settings, fmt.Errorf("invalid STATE_BACKEND %q", settings.StateBackend)
+	}
+
+	switch settings.NetboxDeletionPolicy {
+	case DeletionPolicyDestroy, DeletionPolicyRetain, DeletionPolicyDeprecate:
+	case DeletionPolicyTag:
+		if settings.NetboxRemovedTag == "" {
+			return settings, fmt.Errorf("NETBOX_DELETION_POLICY tag requires NETBOX_REMOVED_TAG")
+		}
+	default:
+		return settings, fmt.Errorf("invalid NETBOX_DELETION_POLICY %q", settings.NetboxDeletionPolicy)
+	}
+
+	if settings.NetboxRemovedCustomField != "" && !customFieldKeyRegex.MatchString(settings.NetboxRemovedCustomField) {
+		return settings, fmt.Errorf("invalid NETBOX_REMOVED_CUSTOM_FIELD %q: must be lowercase letters, digits and single underscores", settings.NetboxRemovedCustomField)
+	}
+	if settings.NetboxProtectionCustomField != "" && !customFieldKeyRegex.MatchString(settings.NetboxProtectionCustomField) {
+		return settings, fmt.Errorf("invalid NETBOX_PROTECTION_CUSTOM_FIELD %q: must be lowercase letters, digits and single underscores", settings.NetboxProtectionCustomField)
+	}
+
+	if settings.KubernetesSyncPolicies && settings.KubernetesClustersFile != "" {
+		return settings, fmt.Errorf("KUBERNETES_SYNC_POLICIES cannot be combined with KUBERNETES_CLUSTERS_FILE")
+	}
+
 	return settings, nil
 }
+
+// validateURL checks that a URL is an absolute http or https URL
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("host is missing")
+	}
+	return nil
+}
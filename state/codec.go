@@ -0,0 +1,212 @@
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// StateEncodingAnnotation records the encoding of the state, unset for plain JSON
+	StateEncodingAnnotation = "netbox-syncer.io/state-encoding"
+	// StateShardsAnnotation records the number of shards of a sharded state
+	StateShardsAnnotation = "netbox-syncer.io/state-shards"
+	// StateGenerationAnnotation records the shard generation of a sharded state,
+	// it is also set as a label on the shards
+	StateGenerationAnnotation = "netbox-syncer.io/state-generation"
+	// StateChecksumAnnotation records the SHA-256 of the joined shards
+	StateChecksumAnnotation = "netbox-syncer.io/state-checksum"
+	// StateOfLabel names the primary ConfigMap a shard belongs to
+	StateOfLabel = "netbox-syncer.io/state-of"
+
+	stateKey           = "prefixes.json"
+	stateCompressedKey = "prefixes.json.gz"
+	stateShardKey      = "shard"
+	stateEncodingGzip  = "gzip"
+)
+
+// encodeState serializes the prefixes of a cluster in the current schema as
+// compact JSON, gzip compressed when compress is set, and returns the payload
+// with its encoding
+func encodeState(cluster string, prefixes []model.Prefix, compress bool) ([]byte, string, error) {
+	if prefixes == nil {
+		prefixes = []model.Prefix{}
+	}
+
+	data, err := json.Marshal(model.State{
+		SchemaVersion: model.StateSchemaVersion,
+		Cluster:       cluster,
+		LastRun:       time.Now().UTC(),
+		Records:       prefixes,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !compress {
+		return data, "", nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, "", err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), stateEncodingGzip, nil
+}
+
+// Export serializes the prefixes of a cluster as an uncompressed state
+// document, for backups
+func Export(cluster string, prefixes []model.Prefix) ([]byte, error) {
+	data, _, err := encodeState(cluster, prefixes, false)
+	return data, err
+}
+
+// Import parses a state document written by Export, or by any release of the
+// syncer, for the cluster
+func Import(data []byte, cluster string) ([]model.Prefix, error) {
+	return decodeState(data, "", cluster)
+}
+
+// decodeState parses a payload produced by encodeState for the cluster,
+// migrating it from older schema versions
+func decodeState(data []byte, encoding string, cluster string) ([]model.Prefix, error) {
+	switch encoding {
+	case "":
+	case stateEncodingGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown state encoding %q", encoding)
+	}
+
+	state, err := migrateState(data, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Cluster != cluster {
+		return nil, fmt.Errorf("state belongs to cluster %q, not %q", state.Cluster, cluster)
+	}
+
+	return state.Records, nil
+}
+
+// splitState cuts the payload into chunks of at most size bytes
+func splitState(data []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	return append(chunks, data)
+}
+
+func shardName(configMapName string, generation string, index int) string {
+	return fmt.Sprintf("%s-shard-%s-%d", configMapName, generation, index)
+}
+
+// stateOfValue returns the StateOfLabel value of the shards of a ConfigMap, its
+// name truncated and suffixed with a hash of it when longer than a label value
+// can be
+func stateOfValue(configMapName string) string {
+	if len(configMapName) <= validation.LabelValueMaxLength {
+		return configMapName
+	}
+	return configMapName[:validation.LabelValueMaxLength-11] + "-" + checksum([]byte(configMapName))[:10]
+}
+
+// staleGeneration reports whether a shard generation was created no later
+// than the replaced one, generations are base 36 timestamps
+func staleGeneration(generation string, replaced string) bool {
+	created, err := strconv.ParseInt(generation, 36, 64)
+	if err != nil {
+		return false
+	}
+	replacedAt, err := strconv.ParseInt(replaced, 36, 64)
+	if err != nil {
+		return false
+	}
+	return created <= replacedAt
+}
+
+// newGeneration returns a unique shard generation so that concurrent writers
+// never write to the same shards
+func newGeneration() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// mergeState applies the changes made from baseline to ours on top of the
+// current state written by another writer. Prefixes are matched by ID.
+func mergeState(baseline []model.Prefix, ours []model.Prefix, current []model.Prefix) []model.Prefix {
+	baselineIDs := make(map[int32]bool)
+	baselineRecords := make(map[int32]model.Prefix)
+	for _, prefix := range baseline {
+		baselineIDs[prefix.PrefixID] = true
+		baselineRecords[prefix.PrefixID] = prefix
+	}
+
+	oursIDs := make(map[int32]bool)
+	oursRecords := make(map[int32]model.Prefix)
+	for _, prefix := range ours {
+		oursIDs[prefix.PrefixID] = true
+		oursRecords[prefix.PrefixID] = prefix
+	}
+
+	var merged []model.Prefix
+	mergedIDs := make(map[int32]bool)
+
+	// Keep the current prefixes unless this run removed them
+	for _, prefix := range current {
+		if baselineIDs[prefix.PrefixID] && !oursIDs[prefix.PrefixID] {
+			continue
+		}
+		// Apply the prefixes this run marked, restored or protected
+		if record := oursRecords[prefix.PrefixID]; baselineIDs[prefix.PrefixID] && changedRecord(baselineRecords[prefix.PrefixID], record) {
+			prefix = record
+		}
+		merged = append(merged, prefix)
+		mergedIDs[prefix.PrefixID] = true
+	}
+
+	// Add the prefixes this run created
+	for _, prefix := range ours {
+		if baselineIDs[prefix.PrefixID] || mergedIDs[prefix.PrefixID] {
+			continue
+		}
+		merged = append(merged, prefix)
+		mergedIDs[prefix.PrefixID] = true
+	}
+
+	return merged
+}
+
+// changedRecord tells whether this run changed the record of a prefix it
+// kept, by marking, restoring, protecting or retaining it
+func changedRecord(baseline model.Prefix, ours model.Prefix) bool {
+	return !ours.RemovedAt.Equal(baseline.RemovedAt) || ours.Protected != baseline.Protected || ours.Retained != baseline.Retained
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,155 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+)
+
+func TestEncodeDecodeState(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []model.Prefix
+		compress bool
+	}{
+		{"Empty", nil, false},
+		{"Empty compressed", nil, true},
+		{"Plain", testPrefixes(3), false},
+		{"Compressed", testPrefixes(3), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, encoding, err := encodeState("prod-a", tt.prefixes, tt.compress)
+			if err != nil {
+				t.Fatalf("encodeState unexpected error: %v", err)
+			}
+
+			result, err := decodeState(data, encoding, "prod-a")
+			if err != nil {
+				t.Fatalf("decodeState unexpected error: %v", err)
+			}
+			if len(result) != len(tt.prefixes) || (len(result) > 0 && !reflect.DeepEqual(result, tt.prefixes)) {
+				t.Errorf("decodeState returned %v, expected %v", result, tt.prefixes)
+			}
+		})
+	}
+}
+
+func TestDecodeStateVersions(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		expected  []model.Prefix
+		expectErr bool
+	}{
+		{
+			name: "Version 0",
+			data: `[{"prefix_id":1,"prefix":"10.0.0.1/32","dns":"10.0.0.1","service_name":"web","namespace":"default"}]`,
+			expected: []model.Prefix{
+				{PrefixID: 1, Prefix: "10.0.0.1/32", ExternalIPs: "10.0.0.1", ServiceName: "web", Namespace: "default", Cluster: "prod-a", ObjectType: model.ObjectTypePrefix},
+			},
+		},
+		{
+			name:     "Version 0 empty",
+			data:     `[]`,
+			expected: []model.Prefix{},
+		},
+		{
+			name: "Version 1",
+			data: `{"schema_version":1,"cluster":"prod-a","records":[{"prefix_id":1,"prefix":"10.0.0.1/32","external_ip":"10.0.0.1","service_name":"web","namespace":"default","cluster":"prod-a","object_type":"ipam.prefix"}]}`,
+			expected: []model.Prefix{
+				{PrefixID: 1, Prefix: "10.0.0.1/32", ExternalIPs: "10.0.0.1", ServiceName: "web", Namespace: "default", Cluster: "prod-a", ObjectType: model.ObjectTypePrefix},
+			},
+		},
+		{
+			name:      "Newer version",
+			data:      `{"schema_version":99,"cluster":"prod-a","records":[]}`,
+			expectErr: true,
+		},
+		{
+			name:      "Other cluster",
+			data:      `{"schema_version":1,"cluster":"prod-b","records":[]}`,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := decodeState([]byte(tt.data), "", "prod-a")
+			if tt.expectErr {
+				if err == nil {
+					t.Fatal("decodeState expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeState unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("decodeState returned %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplitState(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		size     int
+		expected []string
+	}{
+		{"Smaller than size", "abc", 4, []string{"abc"}},
+		{"Exact size", "abcd", 4, []string{"abcd"}},
+		{"Several chunks", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"Empty", "", 4, []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result []string
+			for _, chunk := range splitState([]byte(tt.input), tt.size) {
+				result = append(result, string(chunk))
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("splitState(%q, %d) = %q, expected %q", tt.input, tt.size, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeState(t *testing.T) {
+	prefixes := testPrefixes(5)
+	deprecated := prefixes[1]
+	deprecated.RemovedAt = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	protected := prefixes[1]
+	protected.Protected = true
+
+	tests := []struct {
+		name     string
+		baseline []model.Prefix
+		ours     []model.Prefix
+		current  []model.Prefix
+		expected []model.Prefix
+	}{
+		{"No concurrent change", prefixes[:2], prefixes[:3], prefixes[:2], prefixes[:3]},
+		{"Concurrent addition kept", prefixes[:2], prefixes[:3], []model.Prefix{prefixes[0], prefixes[1], prefixes[3]}, []model.Prefix{prefixes[0], prefixes[1], prefixes[3], prefixes[2]}},
+		{"Our deletion applied", prefixes[:3], prefixes[:2], []model.Prefix{prefixes[0], prefixes[1], prefixes[2], prefixes[4]}, []model.Prefix{prefixes[0], prefixes[1], prefixes[4]}},
+		{"Concurrent deletion kept", prefixes[:3], prefixes[:3], prefixes[:2], prefixes[:2]},
+		{"Same prefix added twice", prefixes[:1], prefixes[:2], prefixes[:2], prefixes[:2]},
+		{"Our deprecation applied", prefixes[:2], []model.Prefix{prefixes[0], deprecated}, []model.Prefix{prefixes[0], prefixes[1], prefixes[3]}, []model.Prefix{prefixes[0], deprecated, prefixes[3]}},
+		{"Our protection applied", prefixes[:2], []model.Prefix{prefixes[0], protected}, prefixes[:2], []model.Prefix{prefixes[0], protected}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := mergeState(tt.baseline, tt.ours, tt.current)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("mergeState returned %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
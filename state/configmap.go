@@ -0,0 +1,329 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/metrics"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigMapStore keeps the state in a ConfigMap, sharded across several
+// ConfigMaps when it outgrows KUBERNETES_STATE_SHARD_SIZE
+type ConfigMapStore struct {
+	client   kubernetes.Interface
+	settings settings.Settings
+
+	// configMap and prefixes are the state as last loaded or saved, used to
+	// detect and merge concurrent state updates
+	configMap *v1.ConfigMap
+	prefixes  []model.Prefix
+}
+
+func NewConfigMapStore(client kubernetes.Interface, settings settings.Settings) *ConfigMapStore {
+	return &ConfigMapStore{
+		client:   client,
+		settings: settings,
+	}
+}
+
+func (s *ConfigMapStore) String() string {
+	return fmt.Sprintf("ConfigMap %s/%s", s.settings.KubernetesConfigMapNamepace, s.settings.KubernetesConfigMapName)
+}
+
+func (s *ConfigMapStore) loaded() []model.Prefix {
+	return s.prefixes
+}
+
+// Load reads the prefixes from the state ConfigMap, creating it when missing
+func (s *ConfigMapStore) Load(ctx context.Context) ([]model.Prefix, error) {
+	var prefixes []model.Prefix
+
+	configMapName := s.settings.KubernetesConfigMapName
+	configMapNamespace := s.settings.KubernetesConfigMapNamepace
+
+	// Try to get existing ConfigMap
+	configMap, err := s.client.CoreV1().ConfigMaps(configMapNamespace).Get(
+		ctx,
+		configMapName,
+		metav1.GetOptions{},
+	)
+
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// ConfigMap not found, create it with empty data
+			newConfigMap := &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      configMapName,
+					Namespace: configMapNamespace,
+				},
+				Data: map[string]string{
+					stateKey: "[]",
+				},
+			}
+
+			created, err := s.client.CoreV1().ConfigMaps(configMapNamespace).Create(
+				ctx,
+				newConfigMap,
+				metav1.CreateOptions{},
+			)
+			if err != nil {
+				return nil, err
+			}
+			slog.Info("Created empty state", logging.KeyCluster, s.settings.KubernetesCluster, "store", s.String())
+			s.configMap = created
+			s.prefixes = prefixes
+			return prefixes, nil
+		}
+		return nil, err
+	}
+
+	// ConfigMap exists, load the data
+	data, err := s.readState(ctx, configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > 0 {
+		prefixes, err = decodeState(data, configMap.Annotations[StateEncodingAnnotation], s.settings.KubernetesCluster)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Loaded state", logging.KeyCluster, s.settings.KubernetesCluster, "store", s.String(), "count", len(prefixes))
+	} else {
+		slog.Warn("State exists but has no prefix data", logging.KeyCluster, s.settings.KubernetesCluster, "store", s.String())
+	}
+
+	s.configMap = configMap
+	s.prefixes = prefixes
+	return prefixes, nil
+}
+
+// readState returns the encoded state held by the ConfigMap, joining the
+// shards of the current generation when the state is sharded
+func (s *ConfigMapStore) readState(ctx context.Context, configMap *v1.ConfigMap) ([]byte, error) {
+	shards, sharded := configMap.Annotations[StateShardsAnnotation]
+	if !sharded {
+		if data, ok := configMap.BinaryData[stateCompressedKey]; ok {
+			return data, nil
+		}
+		return []byte(configMap.Data[stateKey]), nil
+	}
+
+	count, err := strconv.Atoi(shards)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q", StateShardsAnnotation, shards)
+	}
+
+	generation := configMap.Annotations[StateGenerationAnnotation]
+
+	var data []byte
+	for i := 0; i < count; i++ {
+		shard, err := s.client.CoreV1().ConfigMaps(configMap.Namespace).Get(
+			ctx,
+			shardName(configMap.Name, generation, i),
+			metav1.GetOptions{},
+		)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, shard.BinaryData[stateShardKey]...)
+	}
+
+	if checksum(data) != configMap.Annotations[StateChecksumAnnotation] {
+		return nil, fmt.Errorf("checksum mismatch for generation %s of ConfigMap %s/%s", generation, configMap.Namespace, configMap.Name)
+	}
+
+	return data, nil
+}
+
+// Save writes the prefixes to the state ConfigMap. The write is conditional
+// on the ConfigMap being unchanged since it was loaded.
+func (s *ConfigMapStore) Save(ctx context.Context, prefixes []model.Prefix) error {
+	return saveConditionally(ctx, s, s.settings.KubernetesStateConflictPolicy, prefixes)
+}
+
+func (s *ConfigMapStore) save(ctx context.Context, prefixes []model.Prefix) error {
+	data, encoding, err := encodeState(s.settings.KubernetesCluster, prefixes, s.settings.KubernetesStateCompression)
+	if err != nil {
+		return err
+	}
+
+	configMapName := s.settings.KubernetesConfigMapName
+	configMapNamespace := s.settings.KubernetesConfigMapNamepace
+
+	// Update the ConfigMap as it was loaded so the resourceVersion guards
+	// against concurrent writes, fall back to the current one if never loaded
+	var configMap *v1.ConfigMap
+	exists := true
+	if s.configMap != nil {
+		configMap = s.configMap.DeepCopy()
+	} else {
+		configMap, err = s.client.CoreV1().ConfigMaps(configMapNamespace).Get(
+			ctx,
+			configMapName,
+			metav1.GetOptions{},
+		)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if errors.IsNotFound(err) {
+			exists = false
+			configMap = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      configMapName,
+					Namespace: configMapNamespace,
+				},
+			}
+		}
+	}
+
+	if configMap.Annotations == nil {
+		configMap.Annotations = map[string]string{}
+	}
+	replaced := configMap.Annotations[StateGenerationAnnotation]
+
+	for _, annotation := range []string{StateEncodingAnnotation, StateShardsAnnotation, StateGenerationAnnotation, StateChecksumAnnotation} {
+		delete(configMap.Annotations, annotation)
+	}
+	if encoding != "" {
+		configMap.Annotations[StateEncodingAnnotation] = encoding
+	}
+
+	configMap.Data = nil
+	configMap.BinaryData = nil
+
+	// Small states stay inline, larger ones are written to a new generation
+	// of shards first and committed by updating the primary ConfigMap
+	generation := ""
+	shardSize := s.settings.KubernetesStateShardSize
+	switch {
+	case len(data) > shardSize:
+		generation = newGeneration()
+		chunks := splitState(data, shardSize)
+		err = s.writeShards(ctx, configMapName, configMapNamespace, generation, chunks)
+		if err != nil {
+			return err
+		}
+		configMap.Annotations[StateShardsAnnotation] = strconv.Itoa(len(chunks))
+		configMap.Annotations[StateGenerationAnnotation] = generation
+		configMap.Annotations[StateChecksumAnnotation] = checksum(data)
+	case encoding == stateEncodingGzip:
+		configMap.BinaryData = map[string][]byte{stateCompressedKey: data}
+	default:
+		configMap.Data = map[string]string{stateKey: string(data)}
+	}
+
+	var saved *v1.ConfigMap
+	if exists {
+		saved, err = s.client.CoreV1().ConfigMaps(configMapNamespace).Update(
+			ctx,
+			configMap,
+			metav1.UpdateOptions{},
+		)
+		if err != nil {
+			return err
+		}
+		slog.Info("Saved state", logging.KeyCluster, s.settings.KubernetesCluster, "store", s.String(), "count", len(prefixes))
+	} else {
+		saved, err = s.client.CoreV1().ConfigMaps(configMapNamespace).Create(
+			ctx,
+			configMap,
+			metav1.CreateOptions{},
+		)
+		if err != nil {
+			return err
+		}
+		slog.Info("Saved state", logging.KeyCluster, s.settings.KubernetesCluster, "store", s.String(), "count", len(prefixes))
+	}
+
+	s.configMap = saved
+	s.prefixes = prefixes
+	metrics.StateSize.WithLabelValues(s.settings.KubernetesCluster).Set(float64(len(data)))
+
+	// the state is committed, the shards of the generation it replaced and of
+	// older ones are no longer read. Newer ones may belong to a concurrent
+	// writer yet to commit, they are deleted once a later generation replaces
+	// them. Shards left behind when this fails are deleted by the next save.
+	err = s.deleteStaleShards(ctx, configMapName, configMapNamespace, replaced)
+	if err != nil {
+		slog.Warn("Failed to delete stale state shards", logging.KeyCluster, s.settings.KubernetesCluster, "store", s.String(), logging.KeyError, err)
+	}
+	return nil
+}
+
+// writeShards stores the chunks of a state generation in shard ConfigMaps
+func (s *ConfigMapStore) writeShards(ctx context.Context, configMapName string, configMapNamespace string, generation string, chunks [][]byte) error {
+	for i, chunk := range chunks {
+		shard := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      shardName(configMapName, generation, i),
+				Namespace: configMapNamespace,
+				Labels: map[string]string{
+					StateOfLabel:              stateOfValue(configMapName),
+					StateGenerationAnnotation: generation,
+				},
+			},
+			BinaryData: map[string][]byte{
+				stateShardKey: chunk,
+			},
+		}
+
+		_, err := s.client.CoreV1().ConfigMaps(configMapNamespace).Create(
+			ctx,
+			shard,
+			metav1.CreateOptions{},
+		)
+		if errors.IsAlreadyExists(err) {
+			// Leftover of an interrupted save of the same generation
+			_, err = s.client.CoreV1().ConfigMaps(configMapNamespace).Update(
+				ctx,
+				shard,
+				metav1.UpdateOptions{},
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Debug("Wrote state shards", logging.KeyCluster, s.settings.KubernetesCluster, "store", s.String(), "shards", len(chunks), "generation", generation)
+	return nil
+}
+
+// deleteStaleShards removes the shards of the replaced generation and of the
+// generations created before it
+func (s *ConfigMapStore) deleteStaleShards(ctx context.Context, configMapName string, configMapNamespace string, replaced string) error {
+	if replaced == "" {
+		return nil
+	}
+
+	shards, err := s.client.CoreV1().ConfigMaps(configMapNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{StateOfLabel: stateOfValue(configMapName)}.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range shards.Items {
+		if !staleGeneration(shard.Labels[StateGenerationAnnotation], replaced) {
+			continue
+		}
+
+		err := s.client.CoreV1().ConfigMaps(configMapNamespace).Delete(ctx, shard.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,282 @@
+package state
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSaveAndLoadShardedState(t *testing.T) {
+	tests := []struct {
+		name      string
+		compress  bool
+		shardSize int
+		count     int
+		sharded   bool
+	}{
+		{"Inline", false, 1 << 20, 10, false},
+		{"Inline compressed", true, 1 << 20, 10, false},
+		{"Sharded", false, 512, 50, true},
+		{"Sharded compressed", true, 256, 200, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			c := NewConfigMapStore(clientset, settings.Settings{
+				KubernetesConfigMapName:     "state",
+				KubernetesConfigMapNamepace: "default",
+				KubernetesStateCompression:  tt.compress,
+				KubernetesStateShardSize:    tt.shardSize,
+			})
+
+			// Save twice so the second save replaces the first generation
+			for _, count := range []int{tt.count / 2, tt.count} {
+				if err := c.Save(t.Context(), testPrefixes(count)); err != nil {
+					t.Fatalf("Save unexpected error: %v", err)
+				}
+			}
+
+			result, err := c.Load(t.Context())
+			if err != nil {
+				t.Fatalf("Load unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, testPrefixes(tt.count)) {
+				t.Errorf("Load returned %d prefixes, expected %d", len(result), tt.count)
+			}
+
+			configMaps, err := clientset.CoreV1().ConfigMaps("default").List(t.Context(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			primary, err := clientset.CoreV1().ConfigMaps("default").Get(t.Context(), "state", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedShards := 0
+			shards, sharded := primary.Annotations[StateShardsAnnotation]
+			if sharded != tt.sharded {
+				t.Fatalf("state sharded = %v, expected %v", sharded, tt.sharded)
+			}
+			if sharded {
+				fmt.Sscan(shards, &expectedShards)
+			}
+			if len(configMaps.Items) != expectedShards+1 {
+				t.Errorf("found %d ConfigMaps, expected the primary and %d shards", len(configMaps.Items), expectedShards)
+			}
+		})
+	}
+}
+
+func TestSaveStateConflict(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		expectErr bool
+	}{
+		{"Merge", settings.StateConflictPolicyMerge, false},
+		{"Abort", settings.StateConflictPolicyAbort, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			setting := settings.Settings{
+				KubernetesConfigMapName:       "state",
+				KubernetesConfigMapNamepace:   "default",
+				KubernetesStateShardSize:      1 << 20,
+				KubernetesStateConflictPolicy: tt.policy,
+			}
+			c := NewConfigMapStore(clientset, setting)
+			prefixes := testPrefixes(3)
+
+			if _, err := c.Load(t.Context()); err != nil {
+				t.Fatal(err)
+			}
+
+			// Another writer saves a prefix after this run loaded the state
+			other := NewConfigMapStore(clientset, setting)
+			if err := other.Save(t.Context(), prefixes[2:]); err != nil {
+				t.Fatal(err)
+			}
+
+			conflicted := false
+			clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if conflicted {
+					return false, nil, nil
+				}
+				conflicted = true
+				return true, nil, errors.NewConflict(v1.Resource("configmaps"), "state", fmt.Errorf("stale resourceVersion"))
+			})
+
+			err := c.Save(t.Context(), prefixes[:2])
+			if tt.expectErr {
+				if err == nil {
+					t.Fatal("Save expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Save unexpected error: %v", err)
+			}
+
+			result, err := NewConfigMapStore(clientset, setting).Load(t.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []model.Prefix{prefixes[2], prefixes[0], prefixes[1]}
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("merged state = %v, expected %v", result, expected)
+			}
+		})
+	}
+}
+
+func TestSaveStaleShardsNotDeleted(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	c := NewConfigMapStore(clientset, settings.Settings{
+		KubernetesConfigMapName:     "state",
+		KubernetesConfigMapNamepace: "default",
+		KubernetesStateShardSize:    512,
+	})
+	if err := c.Save(t.Context(), testPrefixes(20)); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+
+	failing := true
+	clientset.PrependReactor("delete", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if !failing {
+			return false, nil, nil
+		}
+		return true, nil, errors.NewInternalError(fmt.Errorf("etcd unavailable"))
+	})
+
+	// the new generation is committed before the stale shards are deleted,
+	// failing to delete them does not fail the save
+	if err := c.Save(t.Context(), testPrefixes(30)); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+	result, err := NewConfigMapStore(clientset, c.settings).Load(t.Context())
+	if err != nil {
+		t.Fatalf("Load unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, testPrefixes(30)) {
+		t.Errorf("Load returned %d prefixes, expected the committed 30", len(result))
+	}
+
+	// the next save deletes them
+	failing = false
+	if err := c.Save(t.Context(), testPrefixes(30)); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+	configMaps, err := clientset.CoreV1().ConfigMaps("default").List(t.Context(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, configMap := range configMaps.Items {
+		if generation, found := configMap.Labels[StateGenerationAnnotation]; found && generation != c.configMap.Annotations[StateGenerationAnnotation] {
+			t.Errorf("stale shard %s was not deleted", configMap.Name)
+		}
+	}
+}
+
+func TestSaveKeepsShardsOfConcurrentWriter(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	setting := settings.Settings{
+		KubernetesConfigMapName:     "state",
+		KubernetesConfigMapNamepace: "default",
+		KubernetesStateShardSize:    512,
+	}
+	c := NewConfigMapStore(clientset, setting)
+	if err := c.Save(t.Context(), testPrefixes(20)); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+	replaced := c.configMap.Annotations[StateGenerationAnnotation]
+
+	// another writer has written the shards of its generation, not yet committed
+	concurrent := newGeneration()
+	if err := NewConfigMapStore(clientset, setting).writeShards(t.Context(), "state", "default", concurrent, [][]byte{[]byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Save(t.Context(), testPrefixes(30)); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+
+	generations := make(map[string]bool)
+	configMaps, err := clientset.CoreV1().ConfigMaps("default").List(t.Context(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, configMap := range configMaps.Items {
+		if generation, found := configMap.Labels[StateGenerationAnnotation]; found {
+			generations[generation] = true
+		}
+	}
+	if generations[replaced] {
+		t.Errorf("shards of the replaced generation %s were not deleted", replaced)
+	}
+	if !generations[concurrent] {
+		t.Errorf("shards of the concurrent generation %s were deleted", concurrent)
+	}
+}
+
+func TestSaveShardsOfLongConfigMapName(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	c := NewConfigMapStore(clientset, settings.Settings{
+		KubernetesConfigMapName:     "kubernetes-service-netbox-syncer-state-public-load-balancers-of-prod-a",
+		KubernetesConfigMapNamepace: "default",
+		KubernetesStateShardSize:    512,
+	})
+	if err := c.Save(t.Context(), testPrefixes(20)); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+	if err := c.Save(t.Context(), testPrefixes(30)); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+
+	configMaps, err := clientset.CoreV1().ConfigMaps("default").List(t.Context(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, configMap := range configMaps.Items {
+		value, found := configMap.Labels[StateOfLabel]
+		if !found {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			t.Errorf("shard %s has the invalid label value %q: %v", configMap.Name, value, errs)
+		}
+		if generation := configMap.Labels[StateGenerationAnnotation]; generation != c.configMap.Annotations[StateGenerationAnnotation] {
+			t.Errorf("stale shard %s was not deleted", configMap.Name)
+		}
+	}
+}
+
+func TestLoadStateOfOtherCluster(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	setting := settings.Settings{
+		KubernetesCluster:           "prod-a",
+		KubernetesConfigMapName:     "state",
+		KubernetesConfigMapNamepace: "default",
+		KubernetesStateShardSize:    1 << 20,
+	}
+	if err := NewConfigMapStore(clientset, setting).Save(t.Context(), testPrefixes(3)); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+
+	setting.KubernetesCluster = "prod-b"
+	if _, err := NewConfigMapStore(clientset, setting).Load(t.Context()); err == nil {
+		t.Error("Load of the state of another cluster expected error but got none")
+	}
+}
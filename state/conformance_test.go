@@ -0,0 +1,282 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// storeFactory builds a store with an empty state. seed makes the given
+// prefixes the saved state, for most backends it is the store's own Save.
+type storeFactory func(t *testing.T) (store Store, seed func([]model.Prefix) error)
+
+func testPrefixes(count int) []model.Prefix {
+	var prefixes []model.Prefix
+	for i := 0; i < count; i++ {
+		ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		prefixes = append(prefixes, model.Prefix{
+			PrefixID:    int32(i + 1),
+			Prefix:      ip + "/32",
+			ExternalIPs: ip,
+			ServiceName: fmt.Sprintf("service-%d", i),
+			Namespace:   "default",
+			Cluster:     "prod-a",
+			ObjectType:  model.ObjectTypePrefix,
+		})
+	}
+	return prefixes
+}
+
+func sortedPrefixes(prefixes []model.Prefix) []model.Prefix {
+	sorted := append([]model.Prefix{}, prefixes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PrefixID < sorted[j].PrefixID })
+	return sorted
+}
+
+// testStoreConformance checks the behaviour every Store backend must provide
+func testStoreConformance(t *testing.T, newStore storeFactory) {
+	steps := []struct {
+		name     string
+		prefixes []model.Prefix
+	}{
+		{"Save", testPrefixes(3)},
+		{"Grow", testPrefixes(40)},
+		{"Shrink", testPrefixes(40)[10:12]},
+		{"Empty", nil},
+	}
+
+	t.Run("Load empty state", func(t *testing.T) {
+		store, _ := newStore(t)
+
+		result, err := store.Load(t.Context())
+		if err != nil {
+			t.Fatalf("Load unexpected error: %v", err)
+		}
+		if len(result) != 0 {
+			t.Errorf("Load returned %d prefixes, expected none", len(result))
+		}
+	})
+
+	t.Run("Round trip", func(t *testing.T) {
+		store, seed := newStore(t)
+
+		for _, step := range steps {
+			if _, err := store.Load(t.Context()); err != nil {
+				t.Fatalf("%s: Load unexpected error: %v", step.name, err)
+			}
+			if err := seed(step.prefixes); err != nil {
+				t.Fatalf("%s: seed unexpected error: %v", step.name, err)
+			}
+			if err := store.Save(t.Context(), step.prefixes); err != nil {
+				t.Fatalf("%s: Save unexpected error: %v", step.name, err)
+			}
+
+			result, err := store.Load(t.Context())
+			if err != nil {
+				t.Fatalf("%s: Load unexpected error: %v", step.name, err)
+			}
+			if len(result) != len(step.prefixes) || (len(result) > 0 && !reflect.DeepEqual(sortedPrefixes(result), sortedPrefixes(step.prefixes))) {
+				t.Errorf("%s: Load returned %v, expected %v", step.name, result, step.prefixes)
+			}
+		}
+	})
+}
+
+func TestConfigMapStoreConformance(t *testing.T) {
+	for _, shardSize := range []int{1 << 20, 256} {
+		t.Run(fmt.Sprintf("Shard size %d", shardSize), func(t *testing.T) {
+			testStoreConformance(t, func(t *testing.T) (Store, func([]model.Prefix) error) {
+				store := NewConfigMapStore(fake.NewSimpleClientset(), settings.Settings{
+					KubernetesConfigMapName:       "state",
+					KubernetesConfigMapNamepace:   "default",
+					KubernetesStateShardSize:      shardSize,
+					KubernetesStateConflictPolicy: settings.StateConflictPolicyMerge,
+				})
+				return store, noSeed
+			})
+		})
+	}
+}
+
+func TestSecretStoreConformance(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Compression %v", compress), func(t *testing.T) {
+			testStoreConformance(t, func(t *testing.T) (Store, func([]model.Prefix) error) {
+				store := NewSecretStore(fake.NewSimpleClientset(), settings.Settings{
+					KubernetesConfigMapName:       "state",
+					KubernetesConfigMapNamepace:   "default",
+					KubernetesStateCompression:    compress,
+					KubernetesStateConflictPolicy: settings.StateConflictPolicyMerge,
+				})
+				return store, noSeed
+			})
+		})
+	}
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) (Store, func([]model.Prefix) error) {
+		return NewFileStore(filepath.Join(t.TempDir(), "prefixes.json"), "prod-a"), noSeed
+	})
+}
+
+func TestNetboxStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) (Store, func([]model.Prefix) error) {
+		server := newFakeNetbox(t)
+		netboxClient, err := client.NewNetboxClient(settings.Settings{
+			NetboxURL:           server.URL,
+			NetboxAPIToken:      "token",
+			NetboxOwnershipTag:  "k8s-syncer",
+			NetboxRetryAttempts: 1,
+			APITimeout:          time.Second,
+			KubernetesCluster:   "prod-a",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewNetboxStore(netboxClient), server.seed
+	})
+}
+
+func noSeed([]model.Prefix) error {
+	return nil
+}
+
+// fakeNetbox serves the prefix endpoints of Netbox. Every seeded prefix is
+// listed twice, once owned by the cluster under test and once owned by
+// another cluster, to check the store only discovers its own prefixes.
+type fakeNetbox struct {
+	*httptest.Server
+	mu       sync.Mutex
+	prefixes []map[string]interface{}
+}
+
+func newFakeNetbox(t *testing.T) *fakeNetbox {
+	f := &fakeNetbox{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeNetbox) seed(prefixes []model.Prefix) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.prefixes = nil
+	for _, cluster := range []string{"prod-a", "prod-b"} {
+		for _, prefix := range prefixes {
+			id := prefix.PrefixID
+			if cluster != "prod-a" {
+				id += 10000
+			}
+			comments := strings.Join([]string{
+				"Managed by kubernetes-service-netbox-syncer.",
+				"cluster: " + cluster,
+				"namespace: " + prefix.Namespace,
+				"service: " + prefix.ServiceName,
+				"external-ip: " + prefix.ExternalIPs,
+			}, "\n")
+			f.prefixes = append(f.prefixes, map[string]interface{}{
+				"id":       id,
+				"url":      fmt.Sprintf("%s/api/ipam/prefixes/%d/", f.URL, id),
+				"display":  prefix.Prefix,
+				"family":   map[string]interface{}{"value": 4, "label": "IPv4"},
+				"prefix":   prefix.Prefix,
+				"comments": comments,
+				"tags": []map[string]interface{}{{
+					"id":      1,
+					"url":     f.URL + "/api/extras/tags/1/",
+					"display": "k8s-syncer",
+					"name":    "k8s-syncer",
+					"slug":    "k8s-syncer",
+				}},
+				"children": 0,
+				"_depth":   0,
+			})
+		}
+	}
+	return nil
+}
+
+func (f *fakeNetbox) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet && r.URL.Path == "/api/ipam/prefixes/" && r.URL.Query().Get("tag") == "k8s-syncer" {
+		f.list(w, r)
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/ipam/prefixes/"), "/"))
+	if err != nil || (r.Method != http.MethodGet && r.Method != http.MethodPatch) {
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusBadRequest)
+		return
+	}
+	prefix := f.prefix(id)
+	if prefix == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"detail": "Not found."})
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		if comments, found := request["comments"]; found {
+			prefix["comments"] = comments
+		}
+		if tags, found := request["tags"].([]interface{}); found {
+			prefix["tags"] = tags
+		}
+	}
+	json.NewEncoder(w).Encode(prefix)
+}
+
+// list returns the prefixes carrying the ownership tag
+func (f *fakeNetbox) list(w http.ResponseWriter, r *http.Request) {
+	owned := []map[string]interface{}{}
+	for _, prefix := range f.prefixes {
+		tags, _ := json.Marshal(prefix["tags"])
+		if strings.Contains(string(tags), `"slug":"k8s-syncer"`) {
+			owned = append(owned, prefix)
+		}
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	end := min(offset+limit, len(owned))
+	results := []map[string]interface{}{}
+	if offset < end {
+		results = owned[offset:end]
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":   len(owned),
+		"results": results,
+	})
+}
+
+// prefix returns the seeded prefix with the given ID, nil when there is none
+func (f *fakeNetbox) prefix(id int) map[string]interface{} {
+	for _, prefix := range f.prefixes {
+		if fmt.Sprint(prefix["id"]) == strconv.Itoa(id) {
+			return prefix
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/metrics"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+)
+
+// FileStore keeps the state in a local JSON file, for running the syncer
+// outside of a cluster. Writes are atomic but not guarded against
+// concurrent writers.
+type FileStore struct {
+	path    string
+	cluster string
+}
+
+func NewFileStore(path string, cluster string) *FileStore {
+	return &FileStore{
+		path:    path,
+		cluster: cluster,
+	}
+}
+
+func (s *FileStore) String() string {
+	return fmt.Sprintf("file %s", s.path)
+}
+
+// Load reads the prefixes from the file, a missing file is an empty state
+func (s *FileStore) Load(ctx context.Context) ([]model.Prefix, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prefixes, err := decodeState(data, "", s.cluster)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state in %s: %v", s, err)
+	}
+	slog.Info("Loaded state", logging.KeyCluster, s.cluster, "store", s.String(), "count", len(prefixes))
+
+	return prefixes, nil
+}
+
+// Save replaces the file through a temporary file so that readers never see
+// a partially written state
+func (s *FileStore) Save(ctx context.Context, prefixes []model.Prefix) error {
+	data, _, err := encodeState(s.cluster, prefixes, false)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	err = os.Rename(file.Name(), s.path)
+	if err != nil {
+		return err
+	}
+	slog.Info("Saved state", logging.KeyCluster, s.cluster, "store", s.String(), "count", len(prefixes))
+	metrics.StateSize.WithLabelValues(s.cluster).Set(float64(len(data)))
+
+	return nil
+}
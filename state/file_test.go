@@ -0,0 +1,17 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreOtherCluster(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prefixes.json")
+	if err := NewFileStore(path, "prod-a").Save(t.Context(), testPrefixes(3)); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+
+	if _, err := NewFileStore(path, "prod-b").Load(t.Context()); err == nil {
+		t.Error("Load of the state of another cluster expected error but got none")
+	}
+}
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+)
+
+// migrations upgrade a raw state from the schema version at their index to
+// the next one. Add a migration here whenever model.StateSchemaVersion is bumped.
+var migrations = []func(data []byte, cluster string) ([]byte, error){
+	migrateV0,
+}
+
+// migrateState parses a raw state of any known schema version into the
+// current one
+func migrateState(data []byte, cluster string) (model.State, error) {
+	var state model.State
+
+	version, err := schemaVersion(data)
+	if err != nil {
+		return state, err
+	}
+	if version > model.StateSchemaVersion {
+		return state, fmt.Errorf("state schema version %d is newer than the supported version %d", version, model.StateSchemaVersion)
+	}
+
+	for ; version < model.StateSchemaVersion; version++ {
+		data, err = migrations[version](data, cluster)
+		if err != nil {
+			return state, fmt.Errorf("failed to migrate state from schema version %d: %v", version, err)
+		}
+	}
+
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
+
+// schemaVersion returns the schema version of a raw state, the unversioned
+// bare array written by the first releases is version 0
+func schemaVersion(data []byte) (int, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return 0, nil
+	}
+
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return 0, err
+	}
+
+	return header.SchemaVersion, nil
+}
+
+// prefixV0 is a record of schema version 0, the external IP or hostname was
+// stored under the dns key
+type prefixV0 struct {
+	PrefixID    int32  `json:"prefix_id"`
+	Prefix      string `json:"prefix"`
+	ExternalIPs string `json:"dns"`
+	ServiceName string `json:"service_name"`
+	Namespace   string `json:"namespace"`
+}
+
+// migrateV0 wraps the bare array of version 0 records in a version 1 envelope
+func migrateV0(data []byte, cluster string) ([]byte, error) {
+	var records []prefixV0
+	err := json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	state := model.State{
+		SchemaVersion: 1,
+		Cluster:       cluster,
+		Records:       []model.Prefix{},
+	}
+	for _, record := range records {
+		state.Records = append(state.Records, model.Prefix{
+			PrefixID:    record.PrefixID,
+			Prefix:      record.Prefix,
+			ExternalIPs: record.ExternalIPs,
+			ServiceName: record.ServiceName,
+			Namespace:   record.Namespace,
+			Cluster:     cluster,
+			ObjectType:  model.ObjectTypePrefix,
+		})
+	}
+
+	return json.Marshal(state)
+}
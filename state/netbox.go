@@ -0,0 +1,83 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+)
+
+// NetboxStore discovers the state from the prefixes in Netbox carrying the
+// ownership tag, so no state is kept outside of Netbox. NETBOX_OWNERSHIP_TAG
+// must be set for the prefixes to be discoverable.
+type NetboxStore struct {
+	netboxClient *client.NetboxClient
+
+	// prefixes is the state as last loaded or saved, Save only writes the
+	// prefixes whose marks changed since
+	prefixes map[int32]model.Prefix
+}
+
+func NewNetboxStore(netboxClient *client.NetboxClient) *NetboxStore {
+	return &NetboxStore{
+		netboxClient: netboxClient,
+	}
+}
+
+func (s *NetboxStore) String() string {
+	return "Netbox"
+}
+
+// Load lists the owned prefixes of the cluster from Netbox
+func (s *NetboxStore) Load(ctx context.Context) ([]model.Prefix, error) {
+	prefixes, err := s.netboxClient.ListOwnedPrefixes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Discovered owned prefixes in Netbox", "count", len(prefixes))
+
+	s.prefixes = make(map[int32]model.Prefix, len(prefixes))
+	for _, prefix := range prefixes {
+		s.prefixes[prefix.PrefixID] = prefix
+	}
+	return prefixes, nil
+}
+
+// Save records the removal time and the protected and retained marks that
+// changed in the comments of the prefixes, and removes the ownership tag
+// from the loaded prefixes that are no longer in the state, like those of
+// the retain deletion policy. The prefixes themselves are already in Netbox.
+func (s *NetboxStore) Save(ctx context.Context, prefixes []model.Prefix) error {
+	var errs []error
+	saved := make(map[int32]model.Prefix, len(prefixes))
+	for _, prefix := range prefixes {
+		saved[prefix.PrefixID] = prefix
+		if loaded := s.prefixes[prefix.PrefixID]; sameMarks(loaded, prefix) {
+			continue
+		}
+		if err := s.netboxClient.UpdateOwnedPrefix(ctx, prefix); err != nil {
+			errs = append(errs, err)
+			saved[prefix.PrefixID] = s.prefixes[prefix.PrefixID]
+		}
+	}
+
+	for id, prefix := range s.prefixes {
+		if _, found := saved[id]; found {
+			continue
+		}
+		if err := s.netboxClient.ReleasePrefix(ctx, id); err != nil {
+			errs = append(errs, err)
+			saved[id] = prefix
+		}
+	}
+
+	s.prefixes = saved
+	return errors.Join(errs...)
+}
+
+// sameMarks tells whether two records of a prefix carry the same marks
+func sameMarks(a model.Prefix, b model.Prefix) bool {
+	return a.RemovedAt.Equal(b.RemovedAt) && a.Protected == b.Protected && a.Retained == b.Retained
+}
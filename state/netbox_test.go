@@ -0,0 +1,67 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+)
+
+func TestNetboxStoreSave(t *testing.T) {
+	server := newFakeNetbox(t)
+	netboxClient, err := client.NewNetboxClient(settings.Settings{
+		NetboxURL:           server.URL,
+		NetboxAPIToken:      "token",
+		NetboxOwnershipTag:  "k8s-syncer",
+		NetboxRetryAttempts: 1,
+		APITimeout:          time.Second,
+		KubernetesCluster:   "prod-a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewNetboxStore(netboxClient)
+
+	prefixes := testPrefixes(4)
+	server.seed(prefixes[:3])
+	if _, err := store.Load(t.Context()); err != nil {
+		t.Fatalf("Load unexpected error: %v", err)
+	}
+
+	// the first prefix is protected and retained after its service was
+	// removed, the third is no longer tracked and the fourth is unknown
+	marked := prefixes[0]
+	marked.RemovedAt = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	marked.Protected = true
+	marked.Retained = true
+	err = store.Save(t.Context(), []model.Prefix{marked, prefixes[1], prefixes[3]})
+	if err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+
+	result, err := store.Load(t.Context())
+	if err != nil {
+		t.Fatalf("Load unexpected error: %v", err)
+	}
+	if expected := []model.Prefix{marked, prefixes[1]}; !reflect.DeepEqual(sortedPrefixes(result), expected) {
+		t.Errorf("Load returned %v, expected %v", result, expected)
+	}
+	if released := server.prefix(3); released == nil || len(released["tags"].([]interface{})) != 0 {
+		t.Errorf("untracked prefix kept its tags: %v", released)
+	}
+
+	// restoring the prefix clears its marks
+	if err := store.Save(t.Context(), prefixes[:2]); err != nil {
+		t.Fatalf("Save unexpected error: %v", err)
+	}
+	result, err = store.Load(t.Context())
+	if err != nil {
+		t.Fatalf("Load unexpected error: %v", err)
+	}
+	if expected := prefixes[:2]; !reflect.DeepEqual(sortedPrefixes(result), expected) {
+		t.Errorf("Load returned %v, expected %v", result, expected)
+	}
+}
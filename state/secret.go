@@ -0,0 +1,132 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/metrics"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SecretStore keeps the state in a Secret named like the state ConfigMap,
+// for clusters where the prefixes must not be readable by everyone with
+// access to ConfigMaps. The state is not sharded.
+type SecretStore struct {
+	client   kubernetes.Interface
+	settings settings.Settings
+
+	// secret and prefixes are the state as last loaded or saved
+	secret   *v1.Secret
+	prefixes []model.Prefix
+}
+
+func NewSecretStore(client kubernetes.Interface, settings settings.Settings) *SecretStore {
+	return &SecretStore{
+		client:   client,
+		settings: settings,
+	}
+}
+
+func (s *SecretStore) String() string {
+	return fmt.Sprintf("Secret %s/%s", s.settings.KubernetesConfigMapNamepace, s.settings.KubernetesConfigMapName)
+}
+
+func (s *SecretStore) loaded() []model.Prefix {
+	return s.prefixes
+}
+
+// Load reads the prefixes from the state Secret, a missing Secret is an empty state
+func (s *SecretStore) Load(ctx context.Context) ([]model.Prefix, error) {
+	secret, err := s.client.CoreV1().Secrets(s.settings.KubernetesConfigMapNamepace).Get(
+		ctx,
+		s.settings.KubernetesConfigMapName,
+		metav1.GetOptions{},
+	)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			s.secret = nil
+			s.prefixes = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var prefixes []model.Prefix
+
+	encoding := secret.Annotations[StateEncodingAnnotation]
+	key := stateKey
+	if encoding == stateEncodingGzip {
+		key = stateCompressedKey
+	}
+
+	if data := secret.Data[key]; len(data) > 0 {
+		prefixes, err = decodeState(data, encoding, s.settings.KubernetesCluster)
+		if err != nil {
+			return nil, err
+		}
+	}
+	slog.Info("Loaded state", logging.KeyCluster, s.settings.KubernetesCluster, "store", s.String(), "count", len(prefixes))
+
+	s.secret = secret
+	s.prefixes = prefixes
+	return prefixes, nil
+}
+
+// Save writes the prefixes to the state Secret. The write is conditional on
+// the Secret being unchanged since it was loaded.
+func (s *SecretStore) Save(ctx context.Context, prefixes []model.Prefix) error {
+	return saveConditionally(ctx, s, s.settings.KubernetesStateConflictPolicy, prefixes)
+}
+
+func (s *SecretStore) save(ctx context.Context, prefixes []model.Prefix) error {
+	data, encoding, err := encodeState(s.settings.KubernetesCluster, prefixes, s.settings.KubernetesStateCompression)
+	if err != nil {
+		return err
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.settings.KubernetesConfigMapName,
+			Namespace: s.settings.KubernetesConfigMapNamepace,
+		},
+		Type: v1.SecretTypeOpaque,
+	}
+	if s.secret != nil {
+		secret = s.secret.DeepCopy()
+	}
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	delete(secret.Annotations, StateEncodingAnnotation)
+
+	key := stateKey
+	if encoding != "" {
+		secret.Annotations[StateEncodingAnnotation] = encoding
+		key = stateCompressedKey
+	}
+	secret.Data = map[string][]byte{key: data}
+
+	var saved *v1.Secret
+	if s.secret != nil {
+		saved, err = s.client.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	} else {
+		saved, err = s.client.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Saved state", logging.KeyCluster, s.settings.KubernetesCluster, "store", s.String(), "count", len(prefixes))
+	metrics.StateSize.WithLabelValues(s.settings.KubernetesCluster).Set(float64(len(data)))
+
+	s.secret = saved
+	s.prefixes = prefixes
+	return nil
+}
@@ -0,0 +1,72 @@
+// Package state persists the prefixes created by the syncer between runs.
+package state
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const maxStateSaveAttempts = 5
+
+// Store loads and saves the prefixes created by the syncer
+type Store interface {
+	// Load returns the saved prefixes, an empty state is not an error
+	Load(ctx context.Context) ([]model.Prefix, error)
+	// Save replaces the saved prefixes
+	Save(ctx context.Context, prefixes []model.Prefix) error
+}
+
+// NewStore returns the store selected by STATE_BACKEND
+func NewStore(setting settings.Settings, kubernetesClient *client.KubernetesClient, netboxClient *client.NetboxClient) (Store, error) {
+	switch setting.StateBackend {
+	case settings.StateBackendConfigMap:
+		return NewConfigMapStore(kubernetesClient.Client(), setting), nil
+	case settings.StateBackendSecret:
+		return NewSecretStore(kubernetesClient.Client(), setting), nil
+	case settings.StateBackendFile:
+		return NewFileStore(setting.StateFile, setting.KubernetesCluster), nil
+	case settings.StateBackendNetbox:
+		return NewNetboxStore(netboxClient), nil
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", setting.StateBackend)
+	}
+}
+
+// conditionalStore is a store whose writes fail with a conflict when the
+// state changed since it was loaded
+type conditionalStore interface {
+	Load(ctx context.Context) ([]model.Prefix, error)
+	String() string
+	save(ctx context.Context, prefixes []model.Prefix) error
+	loaded() []model.Prefix
+}
+
+// saveConditionally saves the prefixes and handles conflicts according to the
+// conflict policy, either merging the concurrent changes with the changes of
+// this run or aborting
+func saveConditionally(ctx context.Context, store conditionalStore, policy string, prefixes []model.Prefix) error {
+	for attempt := 1; ; attempt++ {
+		err := store.save(ctx, prefixes)
+		if !errors.IsConflict(err) && !errors.IsAlreadyExists(err) {
+			return err
+		}
+
+		if policy != settings.StateConflictPolicyMerge || attempt >= maxStateSaveAttempts {
+			return fmt.Errorf("%s was modified by another writer: %w", store, err)
+		}
+		slog.Warn("State was modified by another writer, merging changes", "store", fmt.Sprint(store))
+
+		baseline := store.loaded()
+		current, err := store.Load(ctx)
+		if err != nil {
+			return err
+		}
+		prefixes = mergeState(baseline, prefixes, current)
+	}
+}
@@ -0,0 +1,39 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+)
+
+// ErrMassDeletion is returned when a sync would delete more prefixes than the
+// deletion guard allows
+var ErrMassDeletion = errors.New("mass deletion blocked")
+
+// CheckDeletionGuard returns ErrMassDeletion when the plan deletes more
+// prefixes than DELETION_GUARD_MAX_COUNT or DELETION_GUARD_MAX_PERCENT of the
+// state allow, unless ALLOW_MASS_DELETION is set. The percentage only applies
+// to states of at least DELETION_GUARD_MIN_INVENTORY prefixes. Retained
+// prefixes are left in Netbox and never trip the guard.
+func (s *Syncer) CheckDeletionGuard(plan Plan) error {
+	if s.Settings.AllowMassDeletion || s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyRetain {
+		return nil
+	}
+	return deletionGuard(len(plan.Delete), len(plan.Prefixes), s.Settings.DeletionGuardMaxCount, s.Settings.DeletionGuardMaxPercent, s.Settings.DeletionGuardMinInventory)
+}
+
+// deletionGuard checks deletions out of an inventory against the limits, a
+// limit of 0 is disabled. maxPercent is ignored for inventories smaller than
+// minInventory.
+func deletionGuard(deletions int, inventory int, maxCount int, maxPercent int, minInventory int) error {
+	if maxCount > 0 && deletions > maxCount {
+		return fmt.Errorf("%w: %d prefixes to delete exceed DELETION_GUARD_MAX_COUNT of %d, set ALLOW_MASS_DELETION to delete them", ErrMassDeletion, deletions, maxCount)
+	}
+
+	if maxPercent > 0 && inventory > 0 && inventory >= minInventory && deletions*100 > maxPercent*inventory {
+		return fmt.Errorf("%w: %d of %d prefixes to delete exceed DELETION_GUARD_MAX_PERCENT of %d%%, set ALLOW_MASS_DELETION to delete them", ErrMassDeletion, deletions, inventory, maxPercent)
+	}
+
+	return nil
+}
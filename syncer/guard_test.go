@@ -0,0 +1,70 @@
+package syncer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+)
+
+func TestDeletionGuard(t *testing.T) {
+	tests := []struct {
+		name         string
+		deletions    int
+		inventory    int
+		maxCount     int
+		maxPercent   int
+		minInventory int
+		blocked      bool
+	}{
+		{"Limits disabled", 10, 10, 0, 0, 0, false},
+		{"Under count", 3, 100, 5, 0, 10, false},
+		{"Count exceeded", 6, 100, 5, 0, 10, true},
+		{"At percent", 5, 10, 0, 50, 10, false},
+		{"Percent exceeded", 6, 10, 0, 50, 10, true},
+		{"Empty service list", 20, 20, 0, 50, 10, true},
+		{"Single prefix", 1, 1, 0, 50, 10, false},
+		{"Small inventory", 2, 3, 0, 50, 10, false},
+		{"Small inventory without minimum", 2, 3, 0, 50, 0, true},
+		{"Count exceeded in small inventory", 3, 3, 2, 50, 10, true},
+		{"Percent exceeded under count", 6, 10, 20, 50, 10, true},
+		{"No deletions", 0, 0, 5, 50, 10, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := deletionGuard(tt.deletions, tt.inventory, tt.maxCount, tt.maxPercent, tt.minInventory)
+			if errors.Is(err, ErrMassDeletion) != tt.blocked {
+				t.Errorf("deletionGuard(%d, %d, %d, %d, %d) = %v, expected blocked %v", tt.deletions, tt.inventory, tt.maxCount, tt.maxPercent, tt.minInventory, err, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestCheckDeletionGuard(t *testing.T) {
+	plan := Plan{
+		Prefixes: []model.Prefix{{PrefixID: 1}, {PrefixID: 2}},
+		Delete:   []model.Prefix{{PrefixID: 1}, {PrefixID: 2}},
+	}
+
+	tests := []struct {
+		name     string
+		settings settings.Settings
+		blocked  bool
+	}{
+		{"Destroy", settings.Settings{NetboxDeletionPolicy: settings.DeletionPolicyDestroy, DeletionGuardMaxPercent: 50}, true},
+		{"Allowed", settings.Settings{NetboxDeletionPolicy: settings.DeletionPolicyDestroy, DeletionGuardMaxPercent: 50, AllowMassDeletion: true}, false},
+		{"Retain", settings.Settings{NetboxDeletionPolicy: settings.DeletionPolicyRetain, DeletionGuardMaxPercent: 50}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Syncer{Settings: tt.settings}
+			err := s.CheckDeletionGuard(plan)
+			if errors.Is(err, ErrMassDeletion) != tt.blocked {
+				t.Errorf("CheckDeletionGuard() = %v, expected blocked %v", err, tt.blocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package syncer
+
+import (
+	"context"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/audit"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/report"
+)
+
+// journaledOperations are the operations that change Netbox
+var journaledOperations = map[string]bool{
+	report.OperationCreate:    true,
+	report.OperationDelete:    true,
+	report.OperationDeprecate: true,
+	report.OperationTag:       true,
+	report.OperationRestore:   true,
+}
+
+// journal appends the changes of the actions to the audit journal, when one
+// is configured, stamped with the current time. before and after are the
+// records of the prefixes before and after the changes.
+func (s *Syncer) journal(ctx context.Context, actions []report.Action, before map[int32]model.Prefix, after map[int32]model.Prefix) error {
+	if s.Journal == nil {
+		return nil
+	}
+
+	entries := journalEntries(logging.RunID(), s.Settings.KubernetesCluster, time.Now().UTC(), actions, before, after)
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.Journal.Append(ctx, entries)
+}
+
+// journalChange journals the actions of a change right after it was made,
+// before holds the records of the prefixes it changed
+func (s *Syncer) journalChange(ctx context.Context, c *change, before ...model.Prefix) {
+	if s.Journal == nil {
+		return
+	}
+
+	beforeRecords := make(map[int32]model.Prefix)
+	for _, prefix := range before {
+		beforeRecords[prefix.PrefixID] = prefix
+	}
+	afterRecords := make(map[int32]model.Prefix)
+	for _, prefix := range c.created {
+		afterRecords[prefix.PrefixID] = prefix
+	}
+	if c.updated != nil {
+		afterRecords[c.updated.PrefixID] = *c.updated
+	}
+
+	c.journalErr = s.journal(ctx, c.actions, beforeRecords, afterRecords)
+	if c.journalErr != nil {
+		s.logger().Error("Failed to journal change", logging.KeyError, c.journalErr)
+	}
+}
+
+// journalEntries returns the journal entries of the actions that changed, or
+// failed to change, Netbox. Actions held back or only recorded, like blocked
+// deletions and retained prefixes, changed nothing and are left out.
+func journalEntries(runID string, cluster string, at time.Time, actions []report.Action, before map[int32]model.Prefix, after map[int32]model.Prefix) []audit.Entry {
+	var entries []audit.Entry
+
+	for _, action := range actions {
+		if !journaledOperations[action.Operation] {
+			continue
+		}
+		if action.Outcome != report.OutcomeSucceeded && action.Outcome != report.OutcomeFailed {
+			continue
+		}
+
+		entry := audit.Entry{
+			Time:      at,
+			RunID:     runID,
+			Cluster:   cluster,
+			Namespace: action.Namespace,
+			Service:   action.Service,
+			IP:        action.IP,
+			Prefix:    action.Prefix,
+			ObjectID:  action.NetboxID,
+			Action:    action.Operation,
+			Outcome:   action.Outcome,
+			Error:     action.Error,
+		}
+		if record, found := before[action.NetboxID]; found && action.Operation != report.OperationCreate {
+			entry.Before = &record
+		}
+		if record, found := after[action.NetboxID]; found && action.Outcome == report.OutcomeSucceeded && action.Operation != report.OperationDelete {
+			entry.After = &record
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
@@ -0,0 +1,126 @@
+package syncer
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/audit"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/report"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+)
+
+func TestJournalEntries(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	created := model.Prefix{PrefixID: 1, Prefix: "10.0.0.1/32", ExternalIPs: "10.0.0.1", ServiceName: "web", Namespace: "default"}
+	removed := model.Prefix{PrefixID: 2, Prefix: "10.0.0.2/32", ExternalIPs: "10.0.0.2", ServiceName: "api", Namespace: "default"}
+	deprecated := model.Prefix{PrefixID: 3, Prefix: "10.0.0.3/32", ExternalIPs: "10.0.0.3", ServiceName: "db", Namespace: "default"}
+	marked := deprecated
+	marked.RemovedAt = at
+	errTimeout := errors.New("timeout")
+
+	entry := func(operation string, prefix model.Prefix, outcome string) audit.Entry {
+		return audit.Entry{Time: at, RunID: "run", Cluster: "prod-a", Namespace: prefix.Namespace, Service: prefix.ServiceName, IP: prefix.ExternalIPs, Prefix: prefix.Prefix, ObjectID: prefix.PrefixID, Action: operation, Outcome: outcome}
+	}
+	blocked := prefixAction(report.OperationDelete, removed, nil)
+	blocked.Outcome = report.OutcomeBlocked
+
+	tests := []struct {
+		name     string
+		actions  []report.Action
+		expected func() []audit.Entry
+	}{
+		{
+			name:    "Create",
+			actions: []report.Action{prefixAction(report.OperationCreate, created, nil)},
+			expected: func() []audit.Entry {
+				e := entry(report.OperationCreate, created, report.OutcomeSucceeded)
+				e.After = &created
+				return []audit.Entry{e}
+			},
+		},
+		{
+			name:    "Delete",
+			actions: []report.Action{prefixAction(report.OperationDelete, removed, nil)},
+			expected: func() []audit.Entry {
+				e := entry(report.OperationDelete, removed, report.OutcomeSucceeded)
+				e.Before = &removed
+				return []audit.Entry{e}
+			},
+		},
+		{
+			name:    "Deprecate",
+			actions: []report.Action{prefixAction(report.OperationDeprecate, deprecated, nil)},
+			expected: func() []audit.Entry {
+				e := entry(report.OperationDeprecate, deprecated, report.OutcomeSucceeded)
+				e.Before = &deprecated
+				e.After = &marked
+				return []audit.Entry{e}
+			},
+		},
+		{
+			name:    "Failed deprecation",
+			actions: []report.Action{prefixAction(report.OperationDeprecate, deprecated, errTimeout)},
+			expected: func() []audit.Entry {
+				e := entry(report.OperationDeprecate, deprecated, report.OutcomeFailed)
+				e.Error = errTimeout.Error()
+				e.Before = &deprecated
+				return []audit.Entry{e}
+			},
+		},
+		{
+			name: "Nothing changed in Netbox",
+			actions: []report.Action{
+				blocked,
+				prefixAction(report.OperationRetain, removed, nil),
+				{Operation: report.OperationSaveState, Outcome: report.OutcomeSucceeded},
+			},
+			expected: func() []audit.Entry { return nil },
+		},
+	}
+
+	before := map[int32]model.Prefix{removed.PrefixID: removed, deprecated.PrefixID: deprecated}
+	after := map[int32]model.Prefix{created.PrefixID: created, deprecated.PrefixID: marked}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := journalEntries("run", "prod-a", at, tt.actions, before, after)
+			if expected := tt.expected(); !reflect.DeepEqual(result, expected) {
+				t.Errorf("journalEntries = %+v, expected %+v", result, expected)
+			}
+		})
+	}
+}
+
+func TestJournalChange(t *testing.T) {
+	journal := audit.NewFileJournal(filepath.Join(t.TempDir(), "audit.jsonl"))
+	s := &Syncer{Settings: settings.Settings{KubernetesCluster: "prod-a"}, Journal: journal}
+	prefix := model.Prefix{PrefixID: 3, Prefix: "10.0.0.3/32", ExternalIPs: "10.0.0.3", ServiceName: "db", Namespace: "default"}
+	marked := prefix
+	marked.RemovedAt = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	start := time.Now()
+	c := change{updated: &marked, actions: []report.Action{prefixAction(report.OperationDeprecate, prefix, nil)}}
+	s.journalChange(t.Context(), &c, prefix)
+	end := time.Now()
+
+	if c.journalErr != nil {
+		t.Fatalf("unexpected error: %v", c.journalErr)
+	}
+	entries, err := journal.Read(t.Context(), audit.Query{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d journal entries, expected 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Time.Before(start) || entry.Time.After(end) {
+		t.Errorf("entry stamped %s, expected the time of the change between %s and %s", entry.Time, start, end)
+	}
+	if !reflect.DeepEqual(entry.Before, &prefix) || !reflect.DeepEqual(entry.After, &marked) {
+		t.Errorf("entry records %+v before and %+v after, expected %+v and %+v", entry.Before, entry.After, prefix, marked)
+	}
+}
@@ -0,0 +1,40 @@
+package syncer
+
+import "sync"
+
+// batch is the range of indexes from start to end, excluded
+type batch struct {
+	start int
+	end   int
+}
+
+// batches splits n indexes into batches of size, or of one index when size
+// is not positive
+func batches(n int, size int) []batch {
+	size = max(size, 1)
+
+	var result []batch
+	for start := 0; start < n; start += size {
+		result = append(result, batch{start: start, end: min(start+size, n)})
+	}
+	return result
+}
+
+// parallel calls fn for every index from 0 to n, running at most concurrency
+// calls at a time, and returns once every call returned
+func parallel(n int, concurrency int, fn func(i int)) {
+	slots := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(i)
+		}()
+	}
+
+	wg.Wait()
+}
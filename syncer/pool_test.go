@@ -0,0 +1,83 @@
+package syncer
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		concurrency int
+	}{
+		{"Sequential", 5, 1},
+		{"Bounded", 20, 4},
+		{"More workers than calls", 3, 10},
+		{"No calls", 0, 4},
+		{"Invalid concurrency", 3, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var running, peak atomic.Int32
+			var mu sync.Mutex
+			called := make(map[int]int)
+
+			parallel(tt.n, tt.concurrency, func(i int) {
+				current := running.Add(1)
+				for {
+					previous := peak.Load()
+					if current <= previous || peak.CompareAndSwap(previous, current) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				running.Add(-1)
+
+				mu.Lock()
+				called[i]++
+				mu.Unlock()
+			})
+
+			if len(called) != tt.n {
+				t.Errorf("called %d indexes, expected %d", len(called), tt.n)
+			}
+			for i, count := range called {
+				if count != 1 {
+					t.Errorf("index %d called %d times", i, count)
+				}
+			}
+			if limit := int32(max(tt.concurrency, 1)); peak.Load() > limit {
+				t.Errorf("%d calls ran at a time, expected at most %d", peak.Load(), limit)
+			}
+		})
+	}
+}
+
+func TestBatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		size     int
+		expected []batch
+	}{
+		{"Bulk disabled", 3, 0, []batch{{0, 1}, {1, 2}, {2, 3}}},
+		{"Even", 4, 2, []batch{{0, 2}, {2, 4}}},
+		{"Remainder", 5, 2, []batch{{0, 2}, {2, 4}, {4, 5}}},
+		{"Larger than n", 3, 50, []batch{{0, 3}}},
+		{"Empty", 0, 50, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := batches(tt.n, tt.size)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("batches(%d, %d) = %v, expected %v", tt.n, tt.size, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+)
+
+// ProtectedPrefix is a prefix of a removed service that is retained in
+// Netbox instead of deleted, Reason tells what protects it. Unchecked is set
+// when its protection could not be checked, it is then only spared for the
+// current run.
+type ProtectedPrefix struct {
+	model.Prefix
+	Reason    string
+	Unchecked bool
+}
+
+// protect moves the prefixes the plan deletes, or marks for deletion after
+// the grace period, to plan.Protected when they are protected by the protect
+// annotation of their service or by NETBOX_PROTECTION_TAG or
+// NETBOX_PROTECTION_CUSTOM_FIELD in Netbox. The deprecate, tag and retain
+// deletion policies never delete a prefix and leave it unprotected.
+func (s *Syncer) protect(ctx context.Context, plan *Plan) {
+	if s.Settings.NetboxDeletionPolicy != settings.DeletionPolicyDestroy {
+		return
+	}
+
+	candidates := append(append([]model.Prefix{}, plan.Delete...), plan.Mark...)
+	reasons := make([]string, len(candidates))
+	errs := make([]error, len(candidates))
+	parallel(len(candidates), s.Settings.NetboxConcurrency, func(i int) {
+		reasons[i], errs[i] = s.protection(ctx, candidates[i])
+	})
+
+	for i, prefix := range candidates {
+		if errs[i] != nil {
+			s.logger().Warn("Cannot check the protection of prefix, keeping it for this run", logging.KeyNamespace, prefix.Namespace, logging.KeyService, prefix.ServiceName, logging.KeyPrefix, prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID, logging.KeyError, errs[i])
+		}
+	}
+
+	plan.Protected = protectedPrefixes(candidates, reasons, errs)
+	protected := make(map[int32]bool)
+	for _, prefix := range plan.Protected {
+		protected[prefix.PrefixID] = true
+	}
+	plan.Delete = unprotected(plan.Delete, protected)
+	plan.Mark = unprotected(plan.Mark, protected)
+}
+
+// protectedPrefixes returns the candidates protected from deletion, with
+// the reasons and errors of their protection checks. A prefix whose check
+// failed is protected rather than risk deleting it.
+func protectedPrefixes(candidates []model.Prefix, reasons []string, errs []error) []ProtectedPrefix {
+	var protected []ProtectedPrefix
+	for i, prefix := range candidates {
+		switch {
+		case errs[i] != nil:
+			protected = append(protected, ProtectedPrefix{Prefix: prefix, Reason: fmt.Sprintf("failed protection check: %v", errs[i]), Unchecked: true})
+		case reasons[i] != "":
+			protected = append(protected, ProtectedPrefix{Prefix: prefix, Reason: reasons[i]})
+		}
+	}
+	return protected
+}
+
+// protection returns what protects a prefix from deletion, empty when
+// nothing does. The annotation recorded in the state is checked first, then
+// the live service, which may still exist while no longer being synced. An
+// orphaned prefix without ownership comments has no service to check.
+func (s *Syncer) protection(ctx context.Context, prefix model.Prefix) (string, error) {
+	if prefix.Protected {
+		return "annotation " + client.ProtectAnnotation, nil
+	}
+
+	if prefix.ServiceName != "" {
+		protected, err := s.Kubernetes.ServiceProtected(ctx, prefix.Namespace, prefix.ServiceName)
+		if err != nil {
+			return "", err
+		}
+		if protected {
+			return "annotation " + client.ProtectAnnotation, nil
+		}
+	}
+
+	return s.Netbox.PrefixProtection(ctx, prefix.PrefixID)
+}
+
+// unprotected returns the prefixes that are not protected
+func unprotected(prefixes []model.Prefix, protected map[int32]bool) []model.Prefix {
+	var result []model.Prefix
+	for _, prefix := range prefixes {
+		if !protected[prefix.PrefixID] {
+			result = append(result, prefix)
+		}
+	}
+	return result
+}
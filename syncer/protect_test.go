@@ -0,0 +1,29 @@
+package syncer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+)
+
+func TestProtectedPrefixes(t *testing.T) {
+	unprotected := model.Prefix{PrefixID: 1, Prefix: "10.0.0.1/32"}
+	tagged := model.Prefix{PrefixID: 2, Prefix: "10.0.0.2/32"}
+	unchecked := model.Prefix{PrefixID: 3, Prefix: "10.0.0.3/32"}
+
+	result := protectedPrefixes(
+		[]model.Prefix{unprotected, tagged, unchecked},
+		[]string{"", "tag k8s-protected", ""},
+		[]error{nil, nil, errors.New("connection refused")},
+	)
+
+	expected := []ProtectedPrefix{
+		{Prefix: tagged, Reason: "tag k8s-protected"},
+		{Prefix: unchecked, Reason: "failed protection check: connection refused", Unchecked: true},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("protectedPrefixes = %+v, expected %+v", result, expected)
+	}
+}
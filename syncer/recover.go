@@ -0,0 +1,244 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/report"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+)
+
+// RecoverResult lists what a recovery found in Netbox. Known prefixes are
+// already in the state, Adopted ones match a live service and are added to
+// it, Orphaned ones match no live service and are left untouched.
+type RecoverResult struct {
+	Known    []model.Prefix
+	Adopted  []model.Prefix
+	Orphaned []client.DiscoveredPrefix
+}
+
+// Recover rebuilds the state from the prefixes the syncer created in Netbox
+// for the cluster. With dryRun the state is not written.
+func (s *Syncer) Recover(ctx context.Context, dryRun bool) (RecoverResult, error) {
+	var result RecoverResult
+
+	existingPrefixes, err := s.State.Load(ctx)
+	if err != nil {
+		return result, fmt.Errorf("cannot load existing state: %v", err)
+	}
+
+	services, err := s.Kubernetes.GetKubernetesService(ctx)
+	if err != nil {
+		return result, fmt.Errorf("error fetching Kubernetes services: %v", err)
+	}
+	s.logger().Info("Fetched Kubernetes services", "count", len(services))
+
+	discovered, err := s.Netbox.DiscoverPrefixes(ctx)
+	if err != nil {
+		return result, fmt.Errorf("error discovering Netbox prefixes: %v", err)
+	}
+	s.logger().Info("Discovered Netbox prefixes", "count", len(discovered))
+
+	result = matchDiscoveredPrefixes(discovered, services, existingPrefixes)
+
+	for _, prefix := range result.Adopted {
+		s.logger().Info("Adopting prefix", logging.KeyNamespace, prefix.Namespace, logging.KeyService, prefix.ServiceName, logging.KeyPrefix, prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID)
+	}
+	for _, prefix := range result.Orphaned {
+		s.logger().Warn("Orphaned prefix", logging.KeyPrefix, prefix.Prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID, "description", prefix.Description)
+	}
+
+	if dryRun {
+		s.logger().Info("Dry run, the state is left unchanged")
+		return result, nil
+	}
+	if len(result.Adopted) == 0 {
+		return result, nil
+	}
+
+	err = s.State.Save(ctx, append(existingPrefixes, result.Adopted...))
+	if err != nil {
+		return result, fmt.Errorf("error saving prefixes to state: %v", err)
+	}
+	s.logger().Info("Updated state with adopted prefixes", "count", len(result.Adopted))
+
+	return result, nil
+}
+
+// GCResult lists the orphaned prefixes gc found in Netbox and what it did
+// with them
+type GCResult struct {
+	Result
+	Orphaned []client.DiscoveredPrefix
+}
+
+// GC removes the prefixes the syncer created in Netbox for the cluster that
+// are neither in the state nor match a live service. They follow the
+// deletion policy like the prefixes of removed services: they are deleted
+// with the destroy policy, once the grace period is over, marked with the
+// deprecate and tag policies and left untouched with the retain policy.
+// Protected prefixes are retained like in a sync, and the deletion guard
+// weighs the deletions against every prefix of the cluster in Netbox. With
+// dryRun nothing is changed.
+func (s *Syncer) GC(ctx context.Context, dryRun bool) (GCResult, error) {
+	var result GCResult
+
+	existingPrefixes, err := s.State.Load(ctx)
+	if err != nil {
+		return result, fmt.Errorf("cannot load existing state: %v", err)
+	}
+
+	services, err := s.Kubernetes.GetKubernetesService(ctx)
+	if err != nil {
+		return result, fmt.Errorf("error fetching Kubernetes services: %v", err)
+	}
+
+	discovered, err := s.Netbox.DiscoverPrefixes(ctx)
+	if err != nil {
+		return result, fmt.Errorf("error discovering Netbox prefixes: %v", err)
+	}
+
+	result.Orphaned = matchDiscoveredPrefixes(discovered, services, existingPrefixes).Orphaned
+
+	// orphaned prefixes match no service, they are planned like the
+	// prefixes of removed services
+	now := time.Now()
+	var plan Plan
+	for _, prefix := range result.Orphaned {
+		plan.Prefixes = append(plan.Prefixes, prefix.Prefix)
+	}
+	s.planPrefixes(&plan, nil, now)
+	s.protect(ctx, &plan)
+
+	for _, prefix := range plan.Protected {
+		result.Protected++
+		action := prefixAction(report.OperationRetain, prefix.Prefix, nil)
+		action.Outcome = report.OutcomeProtected
+		action.Reason = prefix.Reason
+		result.Actions = append(result.Actions, action)
+		if dryRun {
+			s.logger().Info("Would retain protected orphaned prefix", logging.KeyPrefix, prefix.Prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID, "reason", prefix.Reason)
+			continue
+		}
+		s.retainProtected(prefix)
+	}
+
+	// the guard weighs the deletions against every prefix of the cluster
+	plan.Prefixes = nil
+	for _, prefix := range discovered {
+		plan.Prefixes = append(plan.Prefixes, prefix.Prefix)
+	}
+	guardErr := s.CheckDeletionGuard(plan)
+	if guardErr != nil {
+		s.logger().Error("Deletion guard tripped, no orphaned prefix is deleted", "deletions", len(plan.Delete), "prefixes", len(plan.Prefixes), logging.KeyError, guardErr)
+		for _, prefix := range plan.Delete {
+			action := prefixAction(report.OperationDelete, prefix, nil)
+			action.Outcome = report.OutcomeBlocked
+			result.Actions = append(result.Actions, action)
+		}
+		plan.Delete = nil
+	}
+
+	if dryRun {
+		for _, prefix := range plan.Mark {
+			s.logger().Info("Would mark orphaned prefix", logging.KeyPrefix, prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID)
+		}
+		for _, prefix := range plan.Delete {
+			if s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyRetain {
+				s.logger().Info("Would retain orphaned prefix", logging.KeyPrefix, prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID)
+				continue
+			}
+			s.logger().Info("Would delete orphaned prefix", logging.KeyPrefix, prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID)
+		}
+		return result, guardErr
+	}
+
+	// changes in flight outlive ctx like in a sync
+	changeCtx := context.WithoutCancel(ctx)
+	apply := func(c change) error {
+		result.Deleted += c.deleted
+		result.Marked += c.marked
+		result.Actions = append(result.Actions, c.actions...)
+		if c.err != "" {
+			return errors.New(c.err)
+		}
+		// stop rather than change more prefixes without an audit trail
+		if c.journalErr != nil {
+			return fmt.Errorf("error writing the audit journal: %v", c.journalErr)
+		}
+		return nil
+	}
+
+	for _, prefix := range plan.Mark {
+		if err := ctx.Err(); err != nil {
+			return result, fmt.Errorf("gc interrupted: %v", context.Cause(ctx))
+		}
+		if err := apply(s.mark(changeCtx, prefix, now)); err != nil {
+			return result, err
+		}
+	}
+
+	for _, prefix := range plan.Delete {
+		if err := ctx.Err(); err != nil {
+			return result, fmt.Errorf("gc interrupted: %v", context.Cause(ctx))
+		}
+		var err error
+		if s.Settings.NetboxDeletionPolicy != settings.DeletionPolicyRetain {
+			err = s.Netbox.DeletePrefix(changeCtx, prefix.PrefixID)
+		}
+		if err := apply(s.deleted(changeCtx, prefix, err)); err != nil {
+			return result, err
+		}
+	}
+
+	return result, guardErr
+}
+
+// matchDiscoveredPrefixes sorts the discovered prefixes into known, adopted
+// and orphaned ones
+func matchDiscoveredPrefixes(discovered []client.DiscoveredPrefix, services []model.KubernetesService, existingPrefixes []model.Prefix) RecoverResult {
+	var result RecoverResult
+
+	known := make(map[int32]bool)
+	for _, prefix := range existingPrefixes {
+		known[prefix.PrefixID] = true
+	}
+
+	for _, prefix := range discovered {
+		if known[prefix.PrefixID] {
+			result.Known = append(result.Known, prefix.Prefix)
+			continue
+		}
+
+		service, found := matchService(prefix, services)
+		if !found {
+			result.Orphaned = append(result.Orphaned, prefix)
+			continue
+		}
+
+		adopted := prefix.Prefix
+		adopted.ExternalIPs = service.ExternalIPs
+		adopted.ServiceName = service.Name
+		adopted.Namespace = service.Namespace
+		result.Adopted = append(result.Adopted, adopted)
+	}
+
+	return result
+}
+
+// matchService finds the live service a discovered prefix was created for from
+// its ownership comments
+func matchService(prefix client.DiscoveredPrefix, services []model.KubernetesService) (model.KubernetesService, bool) {
+	for _, service := range services {
+		if service.Name == prefix.ServiceName && service.Namespace == prefix.Namespace && service.ExternalIPs == prefix.ExternalIPs {
+			return service, true
+		}
+	}
+
+	return model.KubernetesService{}, false
+}
@@ -0,0 +1,367 @@
+package syncer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/report"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/state"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMatchDiscoveredPrefixes(t *testing.T) {
+	services := []model.KubernetesService{
+		{Name: "web", Namespace: "default", ExternalIPs: "10.0.0.1"},
+		{Name: "api-gw", Namespace: "edge-ns", ExternalIPs: "lb-1.example.com"},
+		{Name: "db", Namespace: "data", ExternalIPs: "10.0.0.3"},
+	}
+	discovered := func(id int32, prefix string, externalIPs string, service string, namespace string) client.DiscoveredPrefix {
+		return client.DiscoveredPrefix{
+			Prefix: model.Prefix{PrefixID: id, Prefix: prefix, ExternalIPs: externalIPs, ServiceName: service, Namespace: namespace, Cluster: "prod-a"},
+		}
+	}
+
+	tests := []struct {
+		name             string
+		discovered       []client.DiscoveredPrefix
+		existing         []model.Prefix
+		expectedKnown    []int32
+		expectedAdopted  []model.Prefix
+		expectedOrphaned []int32
+	}{
+		{
+			name:       "External IP",
+			discovered: []client.DiscoveredPrefix{discovered(1, "10.0.0.1/32", "10.0.0.1", "web", "default")},
+			expectedAdopted: []model.Prefix{
+				{PrefixID: 1, Prefix: "10.0.0.1/32", ExternalIPs: "10.0.0.1", ServiceName: "web", Namespace: "default", Cluster: "prod-a"},
+			},
+		},
+		{
+			name:       "External hostname",
+			discovered: []client.DiscoveredPrefix{discovered(2, "10.1.0.7/32", "lb-1.example.com", "api-gw", "edge-ns")},
+			expectedAdopted: []model.Prefix{
+				{PrefixID: 2, Prefix: "10.1.0.7/32", ExternalIPs: "lb-1.example.com", ServiceName: "api-gw", Namespace: "edge-ns", Cluster: "prod-a"},
+			},
+		},
+		{
+			name: "Orphaned",
+			discovered: []client.DiscoveredPrefix{
+				discovered(4, "10.0.0.9/32", "10.0.0.9", "gone", "default"),
+				discovered(5, "10.0.0.1/32", "10.0.0.1", "web", "other"),
+				discovered(6, "10.0.0.3/32", "10.0.0.4", "db", "data"),
+			},
+			expectedOrphaned: []int32{4, 5, 6},
+		},
+		{
+			name:          "Known",
+			discovered:    []client.DiscoveredPrefix{discovered(1, "10.0.0.1/32", "10.0.0.1", "web", "default")},
+			existing:      []model.Prefix{{PrefixID: 1}},
+			expectedKnown: []int32{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := matchDiscoveredPrefixes(tt.discovered, services, tt.existing)
+
+			var known, orphaned []int32
+			for _, prefix := range result.Known {
+				known = append(known, prefix.PrefixID)
+			}
+			for _, prefix := range result.Orphaned {
+				orphaned = append(orphaned, prefix.PrefixID)
+			}
+
+			if !reflect.DeepEqual(known, tt.expectedKnown) {
+				t.Errorf("known = %v, expected %v", known, tt.expectedKnown)
+			}
+			if !reflect.DeepEqual(result.Adopted, tt.expectedAdopted) {
+				t.Errorf("adopted = %v, expected %v", result.Adopted, tt.expectedAdopted)
+			}
+			if !reflect.DeepEqual(orphaned, tt.expectedOrphaned) {
+				t.Errorf("orphaned = %v, expected %v", orphaned, tt.expectedOrphaned)
+			}
+		})
+	}
+}
+
+// fakeNetbox serves the prefix endpoints gc uses and counts the requests by
+// method. Deleting a prefix of undeletable fails.
+type fakeNetbox struct {
+	*httptest.Server
+	mu          sync.Mutex
+	prefixes    map[int]map[string]any
+	undeletable map[int]bool
+	requests    map[string]int
+}
+
+func newFakeNetbox(t *testing.T, prefixes ...map[string]any) *fakeNetbox {
+	f := &fakeNetbox{prefixes: make(map[int]map[string]any), undeletable: make(map[int]bool), requests: make(map[string]int)}
+	for _, prefix := range prefixes {
+		f.prefixes[prefix["id"].(int)] = prefix
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// orphanedPrefix returns a prefix created by the syncer for a service of
+// the prod-a cluster that is no longer synced, with the extra comments lines
+func orphanedPrefix(id int, ip string, service string, comments ...string) map[string]any {
+	return map[string]any{
+		"id":          id,
+		"url":         fmt.Sprintf("http://netbox/api/ipam/prefixes/%d/", id),
+		"display":     ip + "/32",
+		"family":      map[string]any{"value": 4, "label": "IPv4"},
+		"prefix":      ip + "/32",
+		"description": ip + "-" + service + "-default-prod-a",
+		"comments": strings.Join(append([]string{
+			"Managed by kubernetes-service-netbox-syncer.",
+			"cluster: prod-a",
+			"namespace: default",
+			"service: " + service,
+			"external-ip: " + ip,
+		}, comments...), "\n"),
+		"tags": []any{map[string]any{
+			"id": 1, "url": "http://netbox/api/extras/tags/1/", "display": "k8s-syncer", "name": "k8s-syncer", "slug": "k8s-syncer",
+		}},
+		"children": 0,
+		"_depth":   0,
+	}
+}
+
+func (f *fakeNetbox) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests[r.Method]++
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path == "/api/ipam/prefixes/" && r.Method == http.MethodGet {
+		var results []map[string]any
+		for id := range len(f.prefixes) + 100 {
+			if prefix, found := f.prefixes[id]; found {
+				results = append(results, prefix)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"count": len(results), "results": results})
+		return
+	}
+
+	id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/ipam/prefixes/"), "/"))
+	prefix, found := f.prefixes[id]
+	if err != nil || !found {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{"detail": "Not found."})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(prefix)
+	case http.MethodPatch:
+		var request map[string]any
+		json.NewDecoder(r.Body).Decode(&request)
+		if comments, found := request["comments"]; found {
+			prefix["comments"] = comments
+		}
+		// gc only ever deprecates prefixes
+		if status, found := request["status"]; found {
+			prefix["status"] = map[string]any{"value": status, "label": "Deprecated"}
+		}
+		json.NewEncoder(w).Encode(prefix)
+	case http.MethodDelete:
+		if f.undeletable[id] {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"detail": "Unable to delete object, dependent objects were found"})
+			return
+		}
+		delete(f.prefixes, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newGCSyncer returns a syncer of the prod-a cluster without state, talking
+// to the fake Netbox and to a fake cluster holding the objects
+func newGCSyncer(t *testing.T, netbox *fakeNetbox, setting settings.Settings, objects ...runtime.Object) *Syncer {
+	setting.KubernetesCluster = "prod-a"
+	setting.NetboxOwnershipTag = "k8s-syncer"
+	setting.NetboxURL = netbox.URL
+	setting.NetboxAPIToken = "token"
+	setting.NetboxRetryAttempts = 1
+	setting.APITimeout = time.Second
+
+	netboxClient, err := client.NewNetboxClient(setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubernetesClient := client.NewKubernetesClientFor(fake.NewSimpleClientset(objects...), nil, setting)
+	t.Cleanup(kubernetesClient.Shutdown)
+
+	return &Syncer{
+		Settings:   setting,
+		Kubernetes: kubernetesClient,
+		State:      state.NewFileStore(filepath.Join(t.TempDir(), "prefixes.json"), "prod-a"),
+		Netbox:     netboxClient,
+	}
+}
+
+func TestGCDeletionPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		dryRun   bool
+		deleted  int
+		marked   int
+		requests map[string]int
+	}{
+		{"Destroy", settings.DeletionPolicyDestroy, false, 2, 0, map[string]int{http.MethodGet: 1, http.MethodDelete: 2}},
+		{"Dry run", settings.DeletionPolicyDestroy, true, 0, 0, map[string]int{http.MethodGet: 1}},
+		{"Deprecate", settings.DeletionPolicyDeprecate, false, 0, 1, map[string]int{http.MethodGet: 2, http.MethodPatch: 1}},
+		{"Retain", settings.DeletionPolicyRetain, false, 0, 0, map[string]int{http.MethodGet: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			netbox := newFakeNetbox(t,
+				orphanedPrefix(1, "10.0.0.1", "old"),
+				orphanedPrefix(2, "10.0.0.2", "old", "removed-at: 2026-01-01T12:00:00Z"),
+			)
+			s := newGCSyncer(t, netbox, settings.Settings{NetboxDeletionPolicy: tt.policy})
+
+			result, err := s.GC(t.Context(), tt.dryRun)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result.Orphaned) != 2 || result.Deleted != tt.deleted || result.Marked != tt.marked {
+				t.Errorf("gc found %d orphans, deleted %d and marked %d, expected 2, %d and %d", len(result.Orphaned), result.Deleted, result.Marked, tt.deleted, tt.marked)
+			}
+			if !reflect.DeepEqual(netbox.requests, tt.requests) {
+				t.Errorf("gc made the requests %v, expected %v", netbox.requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestGCOwnership(t *testing.T) {
+	handMade := orphanedPrefix(2, "10.0.0.2", "old")
+	handMade["comments"] = ""
+	otherCluster := orphanedPrefix(3, "10.0.0.3", "old")
+	otherCluster["comments"] = strings.Replace(otherCluster["comments"].(string), "cluster: prod-a", "cluster: a", 1)
+
+	netbox := newFakeNetbox(t, orphanedPrefix(1, "10.0.0.1", "old"), handMade, otherCluster)
+	s := newGCSyncer(t, netbox, settings.Settings{NetboxDeletionPolicy: settings.DeletionPolicyDestroy})
+
+	result, err := s.GC(t.Context(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Orphaned) != 1 || result.Orphaned[0].PrefixID != 1 || result.Deleted != 1 {
+		t.Errorf("gc found %v and deleted %d prefixes, expected only prefix 1", result.Orphaned, result.Deleted)
+	}
+
+	s.Settings.NetboxOwnershipTag = ""
+	s.Netbox, err = client.NewNetboxClient(s.Settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GC(t.Context(), false); err == nil {
+		t.Error("gc without an ownership tag succeeded, expected an error")
+	}
+	if netbox.requests[http.MethodDelete] != 1 {
+		t.Errorf("gc made %d delete requests, expected 1", netbox.requests[http.MethodDelete])
+	}
+}
+
+func TestGCProtection(t *testing.T) {
+	tagged := orphanedPrefix(1, "10.0.0.1", "old")
+	tagged["tags"] = []any{map[string]any{"id": 1, "url": "http://netbox/api/extras/tags/1/", "display": "keep", "name": "keep", "slug": "keep"}}
+	customField := orphanedPrefix(2, "10.0.0.2", "old")
+	customField["custom_fields"] = map[string]any{"keep": true}
+	netbox := newFakeNetbox(t, tagged, customField, orphanedPrefix(3, "10.0.0.3", "web"), orphanedPrefix(4, "10.0.0.4", "old"))
+
+	// the web service still exists but is no longer synced
+	web := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{client.ProtectAnnotation: "true"}},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeClusterIP, ExternalIPs: []string{"10.0.0.3"}},
+	}
+	s := newGCSyncer(t, netbox, settings.Settings{
+		NetboxDeletionPolicy:        settings.DeletionPolicyDestroy,
+		NetboxProtectionTag:         "keep",
+		NetboxProtectionCustomField: "keep",
+		KubernetesTypeFilter:        []string{string(v1.ServiceTypeLoadBalancer)},
+	}, web)
+
+	result, err := s.GC(t.Context(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Deleted != 1 || result.Protected != 3 {
+		t.Errorf("gc deleted %d and protected %d prefixes, expected 1 and 3", result.Deleted, result.Protected)
+	}
+
+	var retained []string
+	for _, action := range result.Actions {
+		if action.Outcome == report.OutcomeProtected {
+			retained = append(retained, action.Reason)
+		}
+	}
+	if expected := []string{"tag keep", "custom field keep", "annotation " + client.ProtectAnnotation}; !reflect.DeepEqual(retained, expected) {
+		t.Errorf("gc retained prefixes protected by %v, expected %v", retained, expected)
+	}
+	if _, found := netbox.prefixes[4]; found || len(netbox.prefixes) != 3 {
+		t.Errorf("gc left the prefixes %v, expected the protected ones", netbox.prefixes)
+	}
+}
+
+func TestGCDeletionGuard(t *testing.T) {
+	tests := []struct {
+		name      string
+		allow     bool
+		expectErr bool
+		deleted   int
+	}{
+		{"Guarded", false, true, 0},
+		{"Mass deletion allowed", true, false, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prefixes []map[string]any
+			for i := 1; i <= 4; i++ {
+				prefixes = append(prefixes, orphanedPrefix(i, fmt.Sprintf("10.0.0.%d", i), "old"))
+			}
+			netbox := newFakeNetbox(t, prefixes...)
+			s := newGCSyncer(t, netbox, settings.Settings{
+				NetboxDeletionPolicy:  settings.DeletionPolicyDestroy,
+				DeletionGuardMaxCount: 3,
+				AllowMassDeletion:     tt.allow,
+			})
+
+			result, err := s.GC(t.Context(), false)
+			if tt.expectErr != errors.Is(err, ErrMassDeletion) {
+				t.Fatalf("gc returned %v, expected the mass deletion error %v", err, tt.expectErr)
+			}
+			if result.Deleted != tt.deleted || netbox.requests[http.MethodDelete] != tt.deleted {
+				t.Errorf("gc deleted %d prefixes with %d requests, expected %d", result.Deleted, netbox.requests[http.MethodDelete], tt.deleted)
+			}
+		})
+	}
+}
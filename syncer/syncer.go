@@ -0,0 +1,647 @@
+// Package syncer reconciles the Kubernetes services of one cluster with Netbox prefixes.
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/audit"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/logging"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/metrics"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/report"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/state"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Syncer syncs the services of a single cluster. Kubernetes is the cluster
+// the services are read from, State keeps the prefixes between runs and
+// Journal, when set, records every change made to Netbox.
+type Syncer struct {
+	Settings   settings.Settings
+	Kubernetes *client.KubernetesClient
+	State      state.Store
+	Netbox     *client.NetboxClient
+	Journal    audit.Journal
+}
+
+// Result summarizes a sync run, Actions lists every change attempted
+type Result struct {
+	Prefixes  int
+	Created   int
+	Deleted   int
+	Marked    int
+	Restored  int
+	Protected int
+	Errors    []string
+	Actions   []report.Action
+}
+
+// logger returns the logger of the cluster
+func (s *Syncer) logger() *slog.Logger {
+	return slog.With(logging.KeyCluster, s.Settings.KubernetesCluster)
+}
+
+// Plan is the difference between the services of a cluster and its state.
+// The prefixes of removed services are marked instead of deleted with the
+// deprecate and tag deletion policies, or until the deletion grace period is
+// over, and restored when their service comes back. Protected prefixes are
+// retained in Netbox instead of deleted and kept in the state.
+type Plan struct {
+	Prefixes  []model.Prefix
+	Services  []model.KubernetesService
+	Create    []model.KubernetesService
+	Delete    []model.Prefix
+	Mark      []model.Prefix
+	Marked    []model.Prefix
+	Restore   []model.Prefix
+	Protected []ProtectedPrefix
+}
+
+// Plan loads the state and the services and computes the prefixes a sync
+// would create and delete, without changing anything
+func (s *Syncer) Plan(ctx context.Context) (Plan, error) {
+	var plan Plan
+
+	// fetch the exisitng prefixes
+	existingPrefixes, err := s.State.Load(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("cannot load existing state: %v", err)
+	}
+
+	services, err := s.Kubernetes.GetKubernetesService(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("error fetching Kubernetes services: %v", err)
+	}
+	s.logger().Info("Fetched Kubernetes services", "count", len(services))
+	metrics.ServicesDiscovered.WithLabelValues(s.Settings.KubernetesCluster).Set(float64(len(services)))
+
+	plan.Services = services
+
+	// Build a map of existing External IPs for quick lookup
+	existingIPMap := make(map[string]model.Prefix)
+	for _, prefix := range existingPrefixes {
+		existingIPMap[prefix.ExternalIPs] = prefix
+	}
+
+	// Build a map of current service External IPs
+	serviceIPMap := make(map[string]model.KubernetesService)
+	for _, service := range services {
+		serviceIPMap[service.ExternalIPs] = service
+	}
+
+	// The protect annotation is recorded while the service exists, it is
+	// gone with the service. A retained prefix is the prefix of the service
+	// again once it comes back.
+	for _, prefix := range existingPrefixes {
+		if service, exists := serviceIPMap[prefix.ExternalIPs]; exists {
+			prefix.Protected = service.Protected
+			prefix.Retained = false
+		}
+		plan.Prefixes = append(plan.Prefixes, prefix)
+	}
+
+	// Find services to create (in Kubernetes but not in Netbox)
+	for _, service := range services {
+		if _, exists := existingIPMap[service.ExternalIPs]; !exists {
+			plan.Create = append(plan.Create, service)
+		}
+	}
+
+	// Find prefixes to delete (in Netbox but not in Kubernetes)
+	s.planPrefixes(&plan, serviceIPMap, time.Now())
+
+	s.protect(ctx, &plan)
+
+	return plan, nil
+}
+
+// planPrefixes sorts the prefixes of the state whose service was removed
+// into the ones to delete, to mark or already marked, and the marked ones
+// whose service came back into the ones to restore
+func (s *Syncer) planPrefixes(plan *Plan, services map[string]model.KubernetesService, now time.Time) {
+	gracePeriod := s.deletionGracePeriod()
+	keep := s.keepsRemovedPrefixes()
+	for _, prefix := range plan.Prefixes {
+		_, exists := services[prefix.ExternalIPs]
+		marked := !prefix.RemovedAt.IsZero()
+		switch {
+		case exists && marked:
+			plan.Restore = append(plan.Restore, prefix)
+		case exists:
+		case (keep || gracePeriod > 0) && !marked:
+			plan.Mark = append(plan.Mark, prefix)
+		case keep || (gracePeriod > 0 && now.Before(prefix.RemovedAt.Add(gracePeriod))):
+			plan.Marked = append(plan.Marked, prefix)
+		default:
+			plan.Delete = append(plan.Delete, prefix)
+		}
+	}
+}
+
+// keepsRemovedPrefixes tells whether the deletion policy marks the prefixes
+// of removed services and keeps tracking them instead of deleting them
+func (s *Syncer) keepsRemovedPrefixes() bool {
+	return s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyDeprecate || s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyTag
+}
+
+// deletionGracePeriod returns how long the prefixes of removed services stay
+// deprecated before the destroy deletion policy deletes them
+func (s *Syncer) deletionGracePeriod() time.Duration {
+	if s.Settings.NetboxDeletionPolicy != settings.DeletionPolicyDestroy {
+		return 0
+	}
+	return s.Settings.NetboxDeletionGracePeriod
+}
+
+// Run performs a full sync of the cluster. Once ctx is done no new change is
+// started, the changes in flight complete and the state is saved with them.
+func (s *Syncer) Run(ctx context.Context) (Result, error) {
+	var result Result
+
+	plan, err := s.Plan(ctx)
+	if err != nil {
+		return result, err
+	}
+	existingPrefixes := plan.Prefixes
+
+	// deleting a large part of the state is more likely a mistyped filter or
+	// an empty service list than intended, the deletions are held back and
+	// the prefixes stay in the state
+	guardErr := s.CheckDeletionGuard(plan)
+	if guardErr != nil {
+		s.logger().Error("Deletion guard tripped, no prefix is deleted", "deletions", len(plan.Delete), "prefixes", len(plan.Prefixes), logging.KeyError, guardErr)
+		for _, prefix := range plan.Delete {
+			action := prefixAction(report.OperationDelete, prefix, nil)
+			action.Outcome = report.OutcomeBlocked
+			result.Actions = append(result.Actions, action)
+		}
+		plan.Delete = nil
+	}
+
+	// changes in flight and the state outlive ctx, each call is still
+	// bounded by API_TIMEOUT
+	changeCtx := context.WithoutCancel(ctx)
+	skipped := 0
+
+	// Create prefixes in Netbox for new services, then delete stale ones,
+	// in batches of NETBOX_BULK_SIZE. Workers only log about their own
+	// batch, the results are merged in plan order once they are done.
+	creates := make([]change, len(plan.Create))
+	createBatches := batches(len(plan.Create), s.Settings.NetboxBulkSize)
+	parallel(len(createBatches), s.Settings.NetboxConcurrency, func(i int) {
+		batch := createBatches[i]
+		if ctx.Err() != nil {
+			skip(creates[batch.start:batch.end])
+			return
+		}
+		s.createBatch(changeCtx, plan.Create[batch.start:batch.end], creates[batch.start:batch.end])
+	})
+
+	deletes := make([]change, len(plan.Delete))
+	deleteBatches := batches(len(plan.Delete), s.Settings.NetboxBulkSize)
+	parallel(len(deleteBatches), s.Settings.NetboxConcurrency, func(i int) {
+		batch := deleteBatches[i]
+		if ctx.Err() != nil {
+			skip(deletes[batch.start:batch.end])
+			return
+		}
+		s.deleteBatch(changeCtx, plan.Delete[batch.start:batch.end], deletes[batch.start:batch.end])
+	})
+	deletedPrefixes := plan.Delete
+
+	// Mark the prefixes of removed services that are kept and restore the
+	// ones whose service came back
+	now := time.Now()
+	updates := make([]change, len(plan.Mark)+len(plan.Restore))
+	parallel(len(updates), s.Settings.NetboxConcurrency, func(i int) {
+		if ctx.Err() != nil {
+			updates[i].skipped = true
+			return
+		}
+		if i < len(plan.Mark) {
+			updates[i] = s.mark(changeCtx, plan.Mark[i], now)
+			return
+		}
+		updates[i] = s.restore(changeCtx, plan.Restore[i-len(plan.Mark)])
+	})
+
+	// Protected prefixes stay in Netbox and in the state, flagged as
+	// retained, so that their service gets them back when it returns
+	deletedPrefixIDs := make(map[int32]bool)
+	updatedRecords := make(map[int32]model.Prefix)
+	for _, prefix := range plan.Protected {
+		if !prefix.Retained {
+			s.retainProtected(prefix)
+		}
+		if !prefix.Unchecked {
+			retained := prefix.Prefix
+			retained.Retained = true
+			updatedRecords[retained.PrefixID] = retained
+		}
+		result.Protected++
+		action := prefixAction(report.OperationRetain, prefix.Prefix, nil)
+		action.Outcome = report.OutcomeProtected
+		action.Reason = prefix.Reason
+		result.Actions = append(result.Actions, action)
+	}
+
+	var journalErrs []error
+	for i, c := range append(append(creates, deletes...), updates...) {
+		if c.skipped {
+			skipped++
+			continue
+		}
+		result.Created += len(c.created)
+		result.Deleted += c.deleted
+		result.Marked += c.marked
+		result.Restored += c.restored
+		result.Actions = append(result.Actions, c.actions...)
+		if c.err != "" {
+			result.Errors = append(result.Errors, c.err)
+		}
+		if c.journalErr != nil {
+			journalErrs = append(journalErrs, c.journalErr)
+		}
+
+		// Add newly created prefixes to existing list
+		existingPrefixes = append(existingPrefixes, c.created...)
+		if c.removed {
+			deletedPrefixIDs[deletedPrefixes[i-len(creates)].PrefixID] = true
+		}
+		if c.updated != nil {
+			updatedRecords[c.updated.PrefixID] = *c.updated
+		}
+	}
+
+	// Build updated prefixes list (existing prefixes minus deleted ones)
+	var updatedPrefixes []model.Prefix
+	for _, prefix := range existingPrefixes {
+		if deletedPrefixIDs[prefix.PrefixID] {
+			continue
+		}
+		if record, updated := updatedRecords[prefix.PrefixID]; updated {
+			prefix = record
+		}
+		updatedPrefixes = append(updatedPrefixes, prefix)
+	}
+
+	s.logger().Info("Updating state", "count", len(updatedPrefixes))
+	result.Prefixes = len(updatedPrefixes)
+
+	// update the latest prefixes to the state
+	err = s.State.Save(changeCtx, updatedPrefixes)
+	saveAction := report.Action{Operation: report.OperationSaveState, Outcome: report.OutcomeSucceeded}
+	if err != nil {
+		s.logger().Error("Failed to save prefixes to state", logging.KeyError, err)
+		result.Errors = append(result.Errors, fmt.Sprintf("save prefixes to state: %v", err))
+		saveAction.Outcome = report.OutcomeFailed
+		saveAction.Error = err.Error()
+	}
+	result.Actions = append(result.Actions, saveAction)
+
+	// every change was journaled as soon as it was made
+	if s.Journal != nil {
+		journalAction := report.Action{Operation: report.OperationWriteJournal, Outcome: report.OutcomeSucceeded}
+		if err := errors.Join(journalErrs...); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("write audit journal: %v", err))
+			journalAction.Outcome = report.OutcomeFailed
+			journalAction.Error = err.Error()
+		}
+		result.Actions = append(result.Actions, journalAction)
+	}
+
+	if s.Settings.KubernetesServiceWriteback && ctx.Err() == nil {
+		// marked and retained prefixes belong to removed services
+		var livePrefixes []model.Prefix
+		for _, prefix := range updatedPrefixes {
+			if prefix.RemovedAt.IsZero() && !prefix.Retained {
+				livePrefixes = append(livePrefixes, prefix)
+			}
+		}
+		// a prefix that failed to delete is still in Netbox, its service
+		// keeps its annotations
+		var removedPrefixes []model.Prefix
+		for _, prefix := range deletedPrefixes {
+			if deletedPrefixIDs[prefix.PrefixID] {
+				removedPrefixes = append(removedPrefixes, prefix)
+			}
+		}
+		s.writebackServices(ctx, livePrefixes, removedPrefixes)
+	}
+
+	metrics.StateRecords.WithLabelValues(s.Settings.KubernetesCluster).Set(float64(len(updatedPrefixes)))
+
+	if skipped > 0 {
+		s.logger().Warn("Sync interrupted", "skipped", skipped, logging.KeyError, context.Cause(ctx))
+		return result, fmt.Errorf("sync interrupted with %d changes not started: %v", skipped, context.Cause(ctx))
+	}
+	if guardErr != nil {
+		return result, guardErr
+	}
+	if len(result.Errors) == 0 {
+		metrics.LastSuccess.WithLabelValues(s.Settings.KubernetesCluster).SetToCurrentTime()
+	}
+
+	return result, nil
+}
+
+// change is the outcome of creating the prefixes of a service or of
+// deleting, marking or restoring a prefix. Removed is set when the prefix
+// leaves the state, updated holds its new record when it stays. journalErr
+// is the error of journaling the change right after it was made.
+type change struct {
+	skipped    bool
+	created    []model.Prefix
+	deleted    int
+	marked     int
+	restored   int
+	removed    bool
+	updated    *model.Prefix
+	actions    []report.Action
+	err        string
+	journalErr error
+}
+
+// createBatch creates the prefixes of a batch of new services with a single
+// bulk request, falling back to one request per service when Netbox rejects
+// the batch so that the failing service is pinpointed. When the outcome of
+// the batch is unknown, the prefixes it may have created are looked up first
+// so that they are not created twice.
+func (s *Syncer) createBatch(ctx context.Context, services []model.KubernetesService, changes []change) {
+	uncertain := false
+	if len(services) > 1 {
+		s.logger().Info("Creating prefixes in bulk", "count", len(services))
+		prefixes, err := s.Netbox.CreatePrefixes(ctx, services)
+		if err == nil {
+			for i, service := range services {
+				changes[i] = s.created(ctx, service, prefixes[i], nil)
+			}
+			return
+		}
+		uncertain = errors.Is(err, client.ErrUncertain)
+		if uncertain {
+			s.logger().Warn("Failed to create prefixes in bulk with an unknown outcome, looking them up before creating them one by one", "count", len(services), logging.KeyError, err)
+		} else {
+			s.logger().Warn("Failed to create prefixes in bulk, creating them one by one", "count", len(services), logging.KeyError, err)
+		}
+	}
+
+	for i, service := range services {
+		if uncertain {
+			prefixes, err := s.Netbox.FindPrefixes(ctx, service)
+			if err != nil {
+				changes[i] = s.created(ctx, service, nil, fmt.Errorf("cannot tell whether the bulk request created the prefix: %v", err))
+				continue
+			}
+			if len(prefixes) > 0 {
+				s.serviceLogger(service).Info("Found prefix created by the bulk request", "count", len(prefixes))
+				changes[i] = s.created(ctx, service, prefixes, nil)
+				continue
+			}
+		}
+
+		s.serviceLogger(service).Info("Creating prefix")
+		prefixes, err := s.Netbox.CreatePrefix(ctx, service)
+		changes[i] = s.created(ctx, service, prefixes, err)
+	}
+}
+
+// deleteBatch deletes a batch of prefixes with a single bulk request, falling
+// back to one request per prefix when the batch fails
+func (s *Syncer) deleteBatch(ctx context.Context, prefixes []model.Prefix, changes []change) {
+	if s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyRetain {
+		for i, prefix := range prefixes {
+			changes[i] = s.deleted(ctx, prefix, nil)
+		}
+		return
+	}
+
+	if len(prefixes) > 1 {
+		s.logger().Info("Deleting prefixes in bulk", "count", len(prefixes))
+		err := s.Netbox.DeletePrefixes(ctx, prefixes)
+		if err == nil {
+			for i, prefix := range prefixes {
+				changes[i] = s.deleted(ctx, prefix, nil)
+			}
+			return
+		}
+		s.logger().Warn("Failed to delete prefixes in bulk, deleting them one by one", "count", len(prefixes), logging.KeyError, err)
+	}
+
+	for i, prefix := range prefixes {
+		changes[i] = s.deleted(ctx, prefix, s.Netbox.DeletePrefix(ctx, prefix.PrefixID))
+	}
+}
+
+// skip marks changes that were not started
+func skip(changes []change) {
+	for i := range changes {
+		changes[i].skipped = true
+	}
+}
+
+func (s *Syncer) serviceLogger(service model.KubernetesService) *slog.Logger {
+	return s.logger().With(logging.KeyNamespace, service.Namespace, logging.KeyService, service.Name, logging.KeyIP, service.ExternalIPs)
+}
+
+// created records the outcome of creating the prefixes of a service
+func (s *Syncer) created(ctx context.Context, service model.KubernetesService, prefixes []model.Prefix, err error) (c change) {
+	defer s.journalChange(ctx, &c)
+
+	log := s.serviceLogger(service)
+	if err != nil {
+		log.Error("Failed to create prefix in Netbox", logging.KeyError, err)
+		c.err = fmt.Sprintf("create prefix for service %s/%s: %v", service.Namespace, service.Name, err)
+		c.actions = append(c.actions, report.Action{
+			Operation: report.OperationCreate,
+			Namespace: service.Namespace,
+			Service:   service.Name,
+			IP:        service.ExternalIPs,
+			Outcome:   report.OutcomeFailed,
+			Error:     err.Error(),
+		})
+		metrics.ObjectsFailed.WithLabelValues(s.Settings.KubernetesCluster, "create").Inc()
+		reason := client.EventReasonPrefixCreateFailed
+		if errors.Is(err, client.ErrDNSResolution) {
+			reason = client.EventReasonDNSResolutionFailed
+			metrics.DNSResolutionFailures.WithLabelValues(s.Settings.KubernetesCluster).Inc()
+		}
+		s.Kubernetes.RecordServiceEvent(service.Namespace, service.Name, service.UID, v1.EventTypeWarning, reason, "Failed to create Netbox prefix: %v", err)
+		return c
+	}
+
+	c.created = prefixes
+	metrics.ObjectsCreated.WithLabelValues(s.Settings.KubernetesCluster).Add(float64(len(prefixes)))
+	for _, prefix := range prefixes {
+		log.Info("Created prefix in Netbox", logging.KeyPrefix, prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID)
+		c.actions = append(c.actions, prefixAction(report.OperationCreate, prefix, nil))
+		s.Kubernetes.RecordServiceEvent(service.Namespace, service.Name, service.UID, v1.EventTypeNormal, client.EventReasonPrefixCreated, "Created Netbox prefix %s (id %d)", prefix.Prefix, prefix.PrefixID)
+	}
+	return c
+}
+
+// deleted records the outcome of deleting the prefix of a removed service,
+// or of only no longer tracking it with the retain deletion policy
+func (s *Syncer) deleted(ctx context.Context, prefix model.Prefix, err error) (c change) {
+	defer s.journalChange(ctx, &c, prefix)
+
+	log := s.logger().With(logging.KeyNamespace, prefix.Namespace, logging.KeyService, prefix.ServiceName, logging.KeyPrefix, prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID)
+	if s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyRetain {
+		log.Info("Retained prefix in Netbox")
+		c.actions = append(c.actions, prefixAction(report.OperationRetain, prefix, nil))
+		c.removed = true
+		return c
+	}
+
+	if err != nil {
+		log.Error("Failed to delete prefix from Netbox", logging.KeyError, err)
+		c.err = fmt.Sprintf("delete prefix %d: %v", prefix.PrefixID, err)
+		c.actions = append(c.actions, prefixAction(report.OperationDelete, prefix, err))
+		metrics.ObjectsFailed.WithLabelValues(s.Settings.KubernetesCluster, "delete").Inc()
+		s.Kubernetes.RecordServiceEvent(prefix.Namespace, prefix.ServiceName, "", v1.EventTypeWarning, client.EventReasonPrefixDeleteFailed, "Failed to delete Netbox prefix %s (id %d): %v", prefix.Prefix, prefix.PrefixID, err)
+		return c
+	}
+
+	log.Info("Deleted prefix from Netbox")
+	c.deleted = 1
+	c.removed = true
+	c.actions = append(c.actions, prefixAction(report.OperationDelete, prefix, nil))
+	metrics.ObjectsDeleted.WithLabelValues(s.Settings.KubernetesCluster).Inc()
+	s.Kubernetes.RecordServiceEvent(prefix.Namespace, prefix.ServiceName, "", v1.EventTypeNormal, client.EventReasonPrefixDeleted, "Deleted Netbox prefix %s (id %d)", prefix.Prefix, prefix.PrefixID)
+	return c
+}
+
+// retainProtected reports a protected prefix of a removed service that is
+// retained in Netbox
+func (s *Syncer) retainProtected(prefix ProtectedPrefix) {
+	s.logger().Info("Retained protected prefix of removed service in Netbox", logging.KeyNamespace, prefix.Namespace, logging.KeyService, prefix.ServiceName, logging.KeyPrefix, prefix.Prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID, "reason", prefix.Reason)
+	s.Kubernetes.RecordServiceEvent(prefix.Namespace, prefix.ServiceName, "", v1.EventTypeNormal, client.EventReasonPrefixProtected, "Retained protected Netbox prefix %s (id %d), protected by %s", prefix.Prefix.Prefix, prefix.PrefixID, prefix.Reason)
+}
+
+// mark marks the prefix of a removed service in Netbox, deprecating it or
+// tagging it with the tag deletion policy. With the destroy deletion policy
+// it is deleted once the grace period is over unless its service comes back.
+func (s *Syncer) mark(ctx context.Context, prefix model.Prefix, now time.Time) (c change) {
+	defer s.journalChange(ctx, &c, prefix)
+
+	operation, reason, message := report.OperationDeprecate, client.EventReasonPrefixDeprecated, "Deprecated"
+	markPrefix := s.Netbox.DeprecatePrefix
+	if s.Settings.NetboxDeletionPolicy == settings.DeletionPolicyTag {
+		operation, reason, message = report.OperationTag, client.EventReasonPrefixTagged, "Tagged"
+		markPrefix = s.Netbox.TagPrefixRemoved
+	}
+
+	log := s.logger().With(logging.KeyNamespace, prefix.Namespace, logging.KeyService, prefix.ServiceName, logging.KeyPrefix, prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID)
+	err := markPrefix(ctx, prefix.PrefixID, now)
+	c.actions = append(c.actions, prefixAction(operation, prefix, err))
+	if err != nil {
+		log.Error("Failed to mark prefix of removed service in Netbox", "operation", operation, logging.KeyError, err)
+		c.err = fmt.Sprintf("%s prefix %d: %v", operation, prefix.PrefixID, err)
+		metrics.ObjectsFailed.WithLabelValues(s.Settings.KubernetesCluster, operation).Inc()
+		return c
+	}
+
+	c.marked = 1
+	prefix.RemovedAt = now.UTC()
+	c.updated = &prefix
+	message = fmt.Sprintf("%s Netbox prefix %s (id %d)", message, prefix.Prefix, prefix.PrefixID)
+	if gracePeriod := s.deletionGracePeriod(); gracePeriod > 0 {
+		deleteAt := now.Add(gracePeriod)
+		log.Info("Marked prefix of removed service in Netbox", "operation", operation, "delete_at", deleteAt)
+		message += ", deleting it after " + deleteAt.UTC().Format(time.RFC3339)
+	} else {
+		log.Info("Marked prefix of removed service in Netbox", "operation", operation)
+	}
+	s.Kubernetes.RecordServiceEvent(prefix.Namespace, prefix.ServiceName, "", v1.EventTypeNormal, reason, "%s", message)
+	return c
+}
+
+// restore removes the marks from the prefix of a service that came back
+func (s *Syncer) restore(ctx context.Context, prefix model.Prefix) (c change) {
+	defer s.journalChange(ctx, &c, prefix)
+
+	log := s.logger().With(logging.KeyNamespace, prefix.Namespace, logging.KeyService, prefix.ServiceName, logging.KeyPrefix, prefix.Prefix, logging.KeyNetboxID, prefix.PrefixID)
+	err := s.Netbox.RestorePrefix(ctx, prefix.PrefixID)
+	c.actions = append(c.actions, prefixAction(report.OperationRestore, prefix, err))
+	if err != nil {
+		log.Error("Failed to restore prefix in Netbox", logging.KeyError, err)
+		c.err = fmt.Sprintf("restore prefix %d: %v", prefix.PrefixID, err)
+		metrics.ObjectsFailed.WithLabelValues(s.Settings.KubernetesCluster, "restore").Inc()
+		return c
+	}
+
+	log.Info("Restored prefix in Netbox")
+	c.restored = 1
+	prefix.RemovedAt = time.Time{}
+	c.updated = &prefix
+	s.Kubernetes.RecordServiceEvent(prefix.Namespace, prefix.ServiceName, "", v1.EventTypeNormal, client.EventReasonPrefixRestored, "Restored Netbox prefix %s (id %d)", prefix.Prefix, prefix.PrefixID)
+	return c
+}
+
+// prefixAction returns the report action of an operation on a prefix
+func prefixAction(operation string, prefix model.Prefix, err error) report.Action {
+	action := report.Action{
+		Operation: operation,
+		Namespace: prefix.Namespace,
+		Service:   prefix.ServiceName,
+		IP:        prefix.ExternalIPs,
+		Prefix:    prefix.Prefix,
+		NetboxID:  prefix.PrefixID,
+		Outcome:   report.OutcomeSucceeded,
+	}
+	if err != nil {
+		action.Outcome = report.OutcomeFailed
+		action.Error = err.Error()
+	}
+	return action
+}
+
+// writebackServices annotates every synced service with its Netbox prefixes
+// and releases the annotations of services that no longer have any
+func (s *Syncer) writebackServices(ctx context.Context, prefixes []model.Prefix, deletedPrefixes []model.Prefix) {
+	lastSynced := time.Now().UTC().Format(time.RFC3339)
+
+	servicePrefixes := make(map[types.NamespacedName][]model.Prefix)
+	for _, prefix := range prefixes {
+		key := types.NamespacedName{Namespace: prefix.Namespace, Name: prefix.ServiceName}
+		servicePrefixes[key] = append(servicePrefixes[key], prefix)
+	}
+
+	for key, prefixes := range servicePrefixes {
+		var ids, urls []string
+		for _, prefix := range prefixes {
+			ids = append(ids, strconv.Itoa(int(prefix.PrefixID)))
+			urls = append(urls, s.Netbox.PrefixURL(prefix.PrefixID))
+		}
+
+		err := s.Kubernetes.ApplyServiceAnnotations(ctx, key.Namespace, key.Name, map[string]string{
+			client.PrefixIDsAnnotation:  strings.Join(ids, ","),
+			client.PrefixURLsAnnotation: strings.Join(urls, ","),
+			client.LastSyncedAnnotation: lastSynced,
+		})
+		if err != nil {
+			s.logger().Error("Failed to write back annotations", logging.KeyNamespace, key.Namespace, logging.KeyService, key.Name, logging.KeyError, err)
+		}
+	}
+
+	for _, prefix := range deletedPrefixes {
+		key := types.NamespacedName{Namespace: prefix.Namespace, Name: prefix.ServiceName}
+		if _, exists := servicePrefixes[key]; exists {
+			continue
+		}
+
+		err := s.Kubernetes.ApplyServiceAnnotations(ctx, key.Namespace, key.Name, nil)
+		if err != nil {
+			s.logger().Error("Failed to remove annotations", logging.KeyNamespace, key.Namespace, logging.KeyService, key.Name, logging.KeyError, err)
+		}
+	}
+}
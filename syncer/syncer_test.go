@@ -0,0 +1,143 @@
+package syncer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/client"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/model"
+	"github.com/zufardhiyaulhaq/kubernetes-service-netbox-syncer/settings"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPlanPrefixes(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	services := map[string]model.KubernetesService{
+		"10.0.0.1": {Name: "web", Namespace: "default", ExternalIPs: "10.0.0.1"},
+		"10.0.0.2": {Name: "api", Namespace: "default", ExternalIPs: "10.0.0.2"},
+	}
+	live := model.Prefix{PrefixID: 1, ExternalIPs: "10.0.0.1"}
+	returned := model.Prefix{PrefixID: 2, ExternalIPs: "10.0.0.2", RemovedAt: now.Add(-time.Minute)}
+	removed := model.Prefix{PrefixID: 3, ExternalIPs: "10.0.0.3"}
+	recent := model.Prefix{PrefixID: 4, ExternalIPs: "10.0.0.4", RemovedAt: now.Add(-time.Minute)}
+	expired := model.Prefix{PrefixID: 5, ExternalIPs: "10.0.0.5", RemovedAt: now.Add(-time.Hour)}
+	prefixes := []model.Prefix{live, returned, removed, recent, expired}
+
+	tests := []struct {
+		name            string
+		settings        settings.Settings
+		expectedDelete  []model.Prefix
+		expectedMark    []model.Prefix
+		expectedMarked  []model.Prefix
+		expectedRestore []model.Prefix
+	}{
+		{
+			name:            "No grace period",
+			settings:        settings.Settings{NetboxDeletionPolicy: settings.DeletionPolicyDestroy},
+			expectedDelete:  []model.Prefix{removed, recent, expired},
+			expectedRestore: []model.Prefix{returned},
+		},
+		{
+			name:            "Grace period",
+			settings:        settings.Settings{NetboxDeletionPolicy: settings.DeletionPolicyDestroy, NetboxDeletionGracePeriod: 10 * time.Minute},
+			expectedDelete:  []model.Prefix{expired},
+			expectedMark:    []model.Prefix{removed},
+			expectedMarked:  []model.Prefix{recent},
+			expectedRestore: []model.Prefix{returned},
+		},
+		{
+			name:            "Deprecate",
+			settings:        settings.Settings{NetboxDeletionPolicy: settings.DeletionPolicyDeprecate, NetboxDeletionGracePeriod: 10 * time.Minute},
+			expectedMark:    []model.Prefix{removed},
+			expectedMarked:  []model.Prefix{recent, expired},
+			expectedRestore: []model.Prefix{returned},
+		},
+		{
+			name:            "Tag",
+			settings:        settings.Settings{NetboxDeletionPolicy: settings.DeletionPolicyTag},
+			expectedMark:    []model.Prefix{removed},
+			expectedMarked:  []model.Prefix{recent, expired},
+			expectedRestore: []model.Prefix{returned},
+		},
+		{
+			name:            "Retain ignores the grace period",
+			settings:        settings.Settings{NetboxDeletionPolicy: settings.DeletionPolicyRetain, NetboxDeletionGracePeriod: 10 * time.Minute},
+			expectedDelete:  []model.Prefix{removed, recent, expired},
+			expectedRestore: []model.Prefix{returned},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Syncer{Settings: tt.settings}
+			plan := Plan{Prefixes: prefixes}
+			s.planPrefixes(&plan, services, now)
+
+			if !reflect.DeepEqual(plan.Delete, tt.expectedDelete) {
+				t.Errorf("Delete = %v, expected %v", plan.Delete, tt.expectedDelete)
+			}
+			if !reflect.DeepEqual(plan.Mark, tt.expectedMark) {
+				t.Errorf("Mark = %v, expected %v", plan.Mark, tt.expectedMark)
+			}
+			if !reflect.DeepEqual(plan.Marked, tt.expectedMarked) {
+				t.Errorf("Marked = %v, expected %v", plan.Marked, tt.expectedMarked)
+			}
+			if !reflect.DeepEqual(plan.Restore, tt.expectedRestore) {
+				t.Errorf("Restore = %v, expected %v", plan.Restore, tt.expectedRestore)
+			}
+		})
+	}
+}
+
+func TestRunWritebackFailedDeletion(t *testing.T) {
+	netbox := newFakeNetbox(t, orphanedPrefix(1, "10.0.0.1", "web"), orphanedPrefix(2, "10.0.0.2", "api"))
+	netbox.undeletable[1] = true
+
+	// both services opted out, their marked prefixes are deleted once the
+	// grace period is over
+	var services []runtime.Object
+	for _, name := range []string{"web", "api"} {
+		services = append(services, &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{client.SyncAnnotation: "false"}},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeLoadBalancer},
+		})
+	}
+	s := newGCSyncer(t, netbox, settings.Settings{
+		NetboxDeletionPolicy:       settings.DeletionPolicyDestroy,
+		NetboxDeletionGracePeriod:  time.Hour,
+		NetboxBulkSize:             1,
+		NetboxConcurrency:          1,
+		KubernetesServiceWriteback: true,
+	}, services...)
+	removedAt := time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Second)
+	err := s.State.Save(t.Context(), []model.Prefix{
+		{PrefixID: 1, Prefix: "10.0.0.1/32", ExternalIPs: "10.0.0.1", ServiceName: "web", Namespace: "default", Cluster: "prod-a", RemovedAt: removedAt},
+		{PrefixID: 2, Prefix: "10.0.0.2/32", ExternalIPs: "10.0.0.2", ServiceName: "api", Namespace: "default", Cluster: "prod-a", RemovedAt: removedAt},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _ := s.Run(t.Context())
+	if result.Deleted != 1 || len(result.Errors) != 1 {
+		t.Fatalf("sync deleted %d prefixes with the errors %v, expected 1 deletion and 1 error", result.Deleted, result.Errors)
+	}
+
+	// the prefix that failed to delete is still in Netbox, its service keeps
+	// the annotations
+	var released []string
+	for _, action := range s.Kubernetes.Client().(*fake.Clientset).Actions() {
+		if action.GetVerb() != "patch" || action.GetResource().Resource != "services" {
+			continue
+		}
+		released = append(released, action.(k8stesting.PatchAction).GetName())
+	}
+	if expected := []string{"api"}; !reflect.DeepEqual(released, expected) {
+		t.Errorf("annotations released on %v, expected only on the service whose prefix was deleted %v", released, expected)
+	}
+}
@@ -0,0 +1,50 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+)
+
+// Verify reports the drift between the services of the cluster, the state
+// and Netbox, without changing anything
+func (s *Syncer) Verify(ctx context.Context) ([]string, error) {
+	var drift []string
+
+	plan, err := s.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range plan.Create {
+		drift = append(drift, fmt.Sprintf("service %s/%s (%s) has no prefix", service.Namespace, service.Name, service.ExternalIPs))
+	}
+	for _, prefix := range plan.Delete {
+		drift = append(drift, fmt.Sprintf("prefix %s (id %d) belongs to the removed service %s/%s", prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName))
+	}
+	for _, prefix := range plan.Mark {
+		drift = append(drift, fmt.Sprintf("prefix %s (id %d) belongs to the removed service %s/%s and is not marked", prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName))
+	}
+	for _, prefix := range plan.Protected {
+		if prefix.Retained {
+			continue
+		}
+		drift = append(drift, fmt.Sprintf("prefix %s (id %d) belongs to the removed service %s/%s and is protected by %s", prefix.Prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName, prefix.Reason))
+	}
+	for _, prefix := range plan.Restore {
+		drift = append(drift, fmt.Sprintf("prefix %s (id %d) of service %s/%s is marked as removed", prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName))
+	}
+
+	for _, prefix := range plan.Prefixes {
+		current, found, err := s.Netbox.LookupPrefix(ctx, prefix.PrefixID)
+		if err != nil {
+			return drift, err
+		}
+		if !found {
+			drift = append(drift, fmt.Sprintf("prefix %s (id %d) of service %s/%s is missing in Netbox", prefix.Prefix, prefix.PrefixID, prefix.Namespace, prefix.ServiceName))
+		} else if current != prefix.Prefix {
+			drift = append(drift, fmt.Sprintf("prefix id %d of service %s/%s is %s in Netbox but %s in the state", prefix.PrefixID, prefix.Namespace, prefix.ServiceName, current, prefix.Prefix))
+		}
+	}
+
+	return drift, nil
+}
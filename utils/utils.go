@@ -2,7 +2,10 @@ package utils
 
 import (
 	"net"
+	"path"
 	"regexp"
+	"slices"
+	"strings"
 )
 
 func CheckIP(data string) bool {
@@ -33,3 +36,32 @@ func GetIPFromDNS(data string) ([]string, error) {
 	}
 	return ipv4s, nil
 }
+
+// MatchName reports whether name equals one of names, matches one of the
+// glob patterns or matches one of the regular expressions.
+func MatchName(name string, names []string, patterns []string, regexes []*regexp.Regexp) bool {
+	if slices.Contains(names, name) {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	for _, re := range regexes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Slugify converts a name to a Netbox slug, lowercase letters, digits,
+// underscores and hyphens
+func Slugify(name string) string {
+	slug := regexp.MustCompile(`[^a-z0-9_-]+`).ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "-")
+	return strings.Trim(slug, "-")
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"regexp"
 	"testing"
 )
 
@@ -110,3 +111,57 @@ func TestGetIPFromDNS(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		names    []string
+		patterns []string
+		regexes  []*regexp.Regexp
+		expected bool
+	}{
+		{"Exact name", "istio-system", []string{"istio-system"}, nil, nil, true},
+		{"Exact name mismatch", "istio-gateway", []string{"istio-system"}, nil, nil, false},
+		{"Glob prefix", "team-payments", nil, []string{"team-*"}, nil, true},
+		{"Glob mismatch", "platform-payments", nil, []string{"team-*"}, nil, false},
+		{"Glob single char", "team-a", nil, []string{"team-?"}, nil, true},
+		{"Regex anchored", "team-42", nil, nil, []*regexp.Regexp{regexp.MustCompile(`^team-[0-9]+$`)}, true},
+		{"Regex mismatch", "team-abc", nil, nil, []*regexp.Regexp{regexp.MustCompile(`^team-[0-9]+$`)}, false},
+		{"Any matcher wins", "kube-system", []string{"default"}, []string{"team-*"}, []*regexp.Regexp{regexp.MustCompile(`^kube-`)}, true},
+		{"No matchers", "default", nil, nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := MatchName(tt.input, tt.names, tt.patterns, tt.regexes)
+			if result != tt.expected {
+				t.Errorf("MatchName(%q) = %v, expected %v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Already a slug", "k8s-syncer", "k8s-syncer"},
+		{"Uppercase", "K8S-Syncer", "k8s-syncer"},
+		{"Spaces", "kubernetes service syncer", "kubernetes-service-syncer"},
+		{"Special chars", "syncer: prod/a", "syncer-prod-a"},
+		{"Underscore kept", "k8s_syncer", "k8s_syncer"},
+		{"Trimmed", "  -syncer-  ", "syncer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Slugify(tt.input)
+			if result != tt.expected {
+				t.Errorf("Slugify(%q) = %q, expected %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}